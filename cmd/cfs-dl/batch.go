@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// batchEntry is one URL from a --batch-file, with its line number so
+// problems can be fixed in the file.
+type batchEntry struct {
+	Line int
+	URL  string
+}
+
+// readBatchFile returns the URLs in path, one per line. Blank lines and
+// lines starting with # are skipped.
+func readBatchFile(path string) ([]batchEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []batchEntry
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, batchEntry{Line: n, URL: line})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no URLs in %s", path)
+	}
+	return entries, nil
+}
+
+// runBatch downloads every entry in turn with cfg, carrying on past failures.
+// Entries for the same video share one manifest fetch.
+func runBatch(ctx context.Context, cfg Config, entries []batchEntry, stdout, stderr io.Writer) int {
+	cache := model.NewManifestCache(cfg.Hooks.withDefaults().ParseManifest)
+	cfg.Hooks.ParseManifest = cache.Get
+
+	failed := 0
+	for i, e := range entries {
+		_, _ = fmt.Fprintf(stdout, "[%d/%d] %s\n", i+1, len(entries), e.URL)
+		c := cfg
+		c.URL = e.URL
+		code := NewRunner(c, stdout, stderr).Run(ctx)
+		if ctx.Err() != nil {
+			reason := cancelReasonOf(ctx)
+			_, _ = fmt.Fprintf(stdout, "Batch stopped after %d of %d: %s\n", i, len(entries), reason.Msg)
+			return reason.Code
+		}
+		if code != 0 {
+			failed++
+		}
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Batch finished: %d of %d failed\n", failed, len(entries))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Dry-run outcomes for a batch entry.
+const (
+	entryOK          = "ok"
+	entryMissing     = "missing"
+	entryDRM         = "drm"
+	entryUnreachable = "unreachable"
+	entryNoMatch     = "no-match"
+)
+
+// dryRunResult is what --dry-run found out about one entry.
+type dryRunResult struct {
+	batchEntry
+	Status string
+	Size   int64 // Estimated bytes of the selected video and audio
+	Detail string
+}
+
+// dryRun resolves every entry's manifest, cfg.Workers at a time, and reports
+// which ones can be downloaded with cfg and roughly how big they are.
+func dryRun(ctx context.Context, cfg Config, entries []batchEntry, stdout io.Writer) int {
+	hooks := cfg.Hooks.withDefaults()
+	// Entries for the same video share one fetch
+	cache := model.NewManifestCache(hooks.ParseManifest)
+
+	results := make([]dryRunResult, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	// The first panic in a worker, reported once every entry is checked
+	var mu sync.Mutex
+	var crash *crashState
+	var panicValue any
+	var panicStack []byte
+	check := func(i int) {
+		defer func() {
+			if v := recover(); v != nil {
+				mu.Lock()
+				if crash == nil {
+					crash = &crashState{URL: entries[i].URL, OutputDir: cfg.OutputDir, Phase: "dry-run"}
+					panicValue, panicStack = v, debug.Stack()
+				}
+				mu.Unlock()
+			}
+		}()
+		results[i] = checkEntry(ctx, cache, cfg, entries[i])
+	}
+	for range max(cfg.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				check(i)
+			}
+		}()
+	}
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if crash != nil {
+		return handlePanic(stdout, *crash, panicValue, panicStack, -1)
+	}
+
+	if ctx.Err() != nil {
+		reason := cancelReasonOf(ctx)
+		_, _ = fmt.Fprintf(stdout, "Dry run cancelled: %s.\n", reason.Msg)
+		return reason.Code
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "LINE\tSTATUS\tSIZE\tURL\tDETAIL")
+	ok, total := 0, int64(0)
+	for _, r := range results {
+		size := "-"
+		if r.Status == entryOK {
+			ok++
+			total += r.Size
+			size = formatBytes(r.Size)
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", r.Line, r.Status, size, r.URL, dash(r.Detail))
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(stdout, "\n%d of %d entries can be downloaded, about %s in total\n", ok, len(results), formatBytes(total))
+	if ok < len(results) {
+		return 1
+	}
+	return 0
+}
+
+// checkEntry fetches an entry's manifest and checks that cfg can download it.
+func checkEntry(ctx context.Context, cache *model.ManifestCache, cfg Config, e batchEntry) dryRunResult {
+	r := dryRunResult{batchEntry: e}
+	manifestUrl, _ := extractManifestUrl(e.URL)
+	mpd, err := cache.Get(ctx, manifestUrl)
+	if err != nil {
+		r.Status, r.Detail = entryUnreachable, err.Error()
+		var se *httpclient.StatusError
+		if errors.As(err, &se) && (se.Code == http.StatusNotFound || se.Code == http.StatusGone) {
+			r.Status = entryMissing
+		}
+		return r
+	}
+	if mpd.Protected() {
+		r.Status, r.Detail = entryDRM, "manifest has ContentProtection, the download would be encrypted"
+		return r
+	}
+
+	duration := mpd.DurationSeconds()
+	for _, kind := range []string{"video", "audio"} {
+		policy := cfg.Video
+		if kind == "audio" {
+			policy = cfg.Audio
+		}
+		rep, err := mpd.Select(kind, policy)
+		if err != nil {
+			r.Status, r.Detail = entryNoMatch, err.Error()
+			return r
+		}
+		if kind == "video" && cfg.Video.Height > 0 && rep.Height != cfg.Video.Height {
+			// The download would fall back to another resolution, which --strict refuses
+			if cfg.Strict {
+				r.Status, r.Detail = entryNoMatch, fmt.Sprintf("%dp not available (--strict)", cfg.Video.Height)
+				return r
+			}
+			r.Detail = fmt.Sprintf("%dp not available, would get %s", cfg.Video.Height, rep.ID)
+		}
+		r.Size += int64(float64(rep.Bandwidth) / 8 * duration)
+	}
+	r.Status = entryOK
+	return r
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeBatchFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write batch file: %v", err)
+	}
+	return path
+}
+
+func TestReadBatchFile(t *testing.T) {
+	path := writeBatchFile(t, "# research", "https://example.com/a/iframe", "", "  https://example.com/b/iframe  ")
+	entries, err := readBatchFile(path)
+	if err != nil {
+		t.Fatalf("readBatchFile failed: %v", err)
+	}
+	expected := []batchEntry{{2, "https://example.com/a/iframe"}, {4, "https://example.com/b/iframe"}}
+	if len(entries) != 2 || entries[0] != expected[0] || entries[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+
+	if _, err := readBatchFile(writeBatchFile(t, "# nothing yet")); err == nil {
+		t.Error("expected error for a file without URLs, got nil")
+	}
+}
+
+// batchManifest is a small video with one 720p and one audio stream of 1 MB each.
+func batchManifest() *model.MPD {
+	return &model.MPD{
+		MediaPresentationDuration: "PT8S",
+		Period: model.Period{
+			AdaptationSets: []model.AdaptationSet{
+				{MimeType: "video/mp4", Representations: []model.Representation{{ID: "720p", Height: 720, Bandwidth: 1000000}}},
+				{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "audio", Bandwidth: 1000000}}},
+			},
+		},
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		mu.Lock()
+		fetches[url]++
+		mu.Unlock()
+		switch {
+		case strings.Contains(url, "/gone/"):
+			return nil, &httpclient.StatusError{Code: 404, Status: "404 Not Found"}
+		case strings.Contains(url, "/down/"):
+			return nil, errors.New("connection refused")
+		case strings.Contains(url, "/drm/"):
+			mpd := batchManifest()
+			mpd.Period.AdaptationSets[0].ContentProtection = []model.ContentProtection{{SchemeIDURI: "urn:mpeg:dash:mp4protection:2011"}}
+			return mpd, nil
+		}
+		return batchManifest(), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		t.Error("--dry-run must not download")
+		return "", nil
+	}
+
+	path := writeBatchFile(t,
+		"https://example.com/ok/iframe",
+		"https://example.com/ok/iframe",
+		"https://example.com/gone/iframe",
+		"https://example.com/down/iframe",
+		"https://example.com/drm/iframe",
+	)
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--batch-file", path, "--dry-run", "--resolution", "720p"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+		t.Errorf("expected exit code 1 with broken entries, got %d", code)
+	}
+
+	// Compare with the table's column padding collapsed
+	out := strings.Join(strings.Fields(stdout.String()), " ")
+	for _, want := range []string{
+		"1 ok",
+		"2 ok",
+		"3 missing",
+		"4 unreachable",
+		"5 drm",
+		"2 of 5 entries can be downloaded, about 3.8 MiB in total",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, stdout.String())
+		}
+	}
+	if n := fetches["https://example.com/ok/manifest/video.mpd"]; n != 1 {
+		t.Errorf("expected duplicate entries to share one manifest fetch, got %d", n)
+	}
+}
+
+func TestRun_DryRunStrict(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return batchManifest(), nil
+	}
+
+	path := writeBatchFile(t, "https://example.com/ok/iframe")
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+			// Only 720p is available, so the download would fall back
+			args := []string{"cfs-dl", "--batch-file", path, "--dry-run", "--resolution", "1080p"}
+			want, status := 0, "1 ok"
+			if strict {
+				args, want, status = append(args, "--strict"), 1, "1 no-match"
+			}
+			if code := run(args, stdout, new(bytes.Buffer), h); code != want {
+				t.Errorf("expected exit code %d, got %d: %s", want, code, stdout.String())
+			}
+			if out := strings.Join(strings.Fields(stdout.String()), " "); !strings.Contains(out, status) {
+				t.Errorf("expected %q in output:\n%s", status, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRun_DryRunPanic(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		if strings.Contains(url, "/bug/") {
+			panic("boom")
+		}
+		return batchManifest(), nil
+	}
+
+	path := writeBatchFile(t, "https://example.com/ok/iframe", "https://example.com/bug/iframe", "https://example.com/bug/iframe")
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--batch-file", path, "--dry-run", "--output-dir", t.TempDir()}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != exitCodePanic {
+		t.Errorf("expected exit code %d, got %d: %s", exitCodePanic, code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "Crash report written to") {
+		t.Errorf("expected crash report message, got %s", stdout.String())
+	}
+}
+
+func TestRun_Batch(t *testing.T) {
+	h := testHooks()
+	fetches := map[string]int{}
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		fetches[url]++
+		if strings.Contains(url, "/gone/") {
+			return nil, &httpclient.StatusError{Code: 404, Status: "404 Not Found"}
+		}
+		return batchManifest(), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "temp.mp4", nil
+	}
+	var outputs []string
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		outputs = append(outputs, o)
+		return nil
+	}
+
+	// The last entry is a second copy of the first, e.g. at another resolution
+	path := writeBatchFile(t, "https://example.com/a/iframe", "https://example.com/gone/iframe", "https://example.com/b/iframe", "https://example.com/a/iframe")
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--batch-file", path, "--output-dir", filepath.Join(t.TempDir(), "{uid}")}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+		t.Errorf("expected exit code 1 after a failed entry, got %d", code)
+	}
+	if len(outputs) != 3 {
+		t.Errorf("expected the entries around the failure to download, got %v", outputs)
+	}
+	if !strings.Contains(stdout.String(), "Batch finished: 1 of 4 failed") {
+		t.Errorf("expected a summary, got %s", stdout.String())
+	}
+	if n := fetches["https://example.com/a/manifest/video.mpd"]; n != 1 {
+		t.Errorf("expected duplicate entries to share one manifest fetch, got %d", n)
+	}
+}
+
+func TestRun_BatchWithURL(t *testing.T) {
+	path := writeBatchFile(t, "https://example.com/a/iframe")
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--batch-file", path, "--url", "https://example.com/b/iframe"}
+	if code := run(args, stdout, new(bytes.Buffer), testHooks()); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// cancelReason says why a run stopped early. It is set as the cause of the
+// run's context, so every path that sees the context end can report it
+// instead of a bare context.Canceled.
+type cancelReason struct {
+	Name string // Short name for progress events, e.g. "interrupted"
+	Msg  string
+	Code int // Exit code, following the shell conventions for signals and timeout(1)
+}
+
+func (r *cancelReason) Error() string { return r.Msg }
+
+var (
+	errInterrupted = &cancelReason{Name: "interrupted", Msg: "interrupted (SIGINT)", Code: 130}
+	errTerminated  = &cancelReason{Name: "terminated", Msg: "terminated (SIGTERM)", Code: 143}
+	errTimedOut    = &cancelReason{Name: "timeout", Msg: "--timeout reached", Code: 124}
+	// errCancelled covers contexts cancelled without a reason, e.g. by code
+	// embedding the Runner, which keep the historical exit code 0.
+	errCancelled = &cancelReason{Name: "cancelled", Msg: "cancelled", Code: 0}
+)
+
+// cancelReasonOf returns why ctx was cancelled, or errCancelled if no reason was given.
+func cancelReasonOf(ctx context.Context) *cancelReason {
+	var r *cancelReason
+	if errors.As(context.Cause(ctx), &r) {
+		return r
+	}
+	return errCancelled
+}
+
+// isCancellation reports whether err means the run was stopped rather than failed.
+func isCancellation(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled)
+}
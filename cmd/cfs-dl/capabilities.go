@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cfs-dl/internal/merger"
+	"encoding/json"
+	"flag"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+)
+
+// version is the release this binary was built from, set with
+// -ldflags "-X main.version=v0.2.0". Without it the module version from the
+// build info is used, if any.
+var version = "dev"
+
+// capabilities is what --capabilities prints, for orchestration tools that
+// check a worker can take a job before dispatching it.
+type capabilities struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	// Protocols maps each streaming protocol to whether it can be downloaded.
+	Protocols map[string]bool `json:"protocols"`
+	// Muxers are the backends that merge the streams; Tools are the other
+	// external programs some features need.
+	Muxers      []toolInfo `json:"muxers"`
+	Tools       []toolInfo `json:"tools"`
+	Subcommands []string   `json:"subcommands"`
+	// Features names the optional behaviours this build has, so a newer
+	// flag can be checked for before it is passed.
+	Features []string `json:"features"`
+}
+
+// toolInfo says whether an external program was found in PATH.
+type toolInfo struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Path      string `json:"path,omitempty"`
+	UsedFor   string `json:"used_for"`
+}
+
+// flagFeatures maps flags to the feature they belong to in
+// capabilities.Features; any other flag is a feature of its own name. ""
+// marks the basic flags that aren't optional. Feature names are never reused
+// for something else, so tools can rely on them across versions.
+var flagFeatures = map[string]string{
+	"url":                "",
+	"output-dir":         "",
+	"filename":           "",
+	"resolution":         "",
+	"workers":            "",
+	"vcodec":             "",
+	"acodec":             "",
+	"audio-lang":         "",
+	"max-bandwidth":      "",
+	"capabilities":       "",
+	"check-dependencies": "",
+	"config":             "",
+	"batch-file":         "batch",
+	"cookie":             "cookies",
+	"exec-dir":           "exec",
+	"exec-timeout":       "exec",
+	"job-id":             "progress-webhook",
+	"no-embed-source":    "embed-source",
+	"no-remux-fallback":  "remux-fallback",
+	"progress-interval":  "progress-webhook",
+	"progress-step":      "progress-webhook",
+	"slow-segment":       "segment-latency",
+	"sync-threshold":     "sync-check",
+	"tonemap":            "hdr-tonemap",
+}
+
+// otherFeatures are the features that have no flag of their own.
+var otherFeatures = []string{
+	"audio-transcode",
+	"header-rules",
+	"resume",
+	"scp-output",
+}
+
+// buildFeatures lists the features of this build from the flags in fs.
+func buildFeatures(fs *flag.FlagSet) []string {
+	names := append([]string(nil), otherFeatures...)
+	if merger.ChecksFreeSpace {
+		names = append(names, "free-space-check")
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		name, ok := flagFeatures[f.Name]
+		if !ok {
+			name = f.Name
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	})
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// buildCapabilities describes this binary with the flags in fs, looking
+// tools up with lookPath.
+func buildCapabilities(fs *flag.FlagSet, lookPath func(string) (string, error)) capabilities {
+	find := func(name, usedFor string) toolInfo {
+		t := toolInfo{Name: name, UsedFor: usedFor}
+		if path, err := lookPath(name); err == nil {
+			t.Available, t.Path = true, path
+		}
+		return t
+	}
+
+	c := capabilities{
+		Name:      "cfs-dl",
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		// Only on-demand DASH is supported; a live manifest has no fixed segment count to fetch
+		Protocols:   map[string]bool{"dash": true, "hls": false, "live": false},
+		Muxers:      []toolInfo{find("ffmpeg", "merging, audio conversion, tonemapping")},
+		Tools:       []toolInfo{find("ffprobe", "sync check, inspect"), find("ssh", "scp:// output")},
+		Subcommands: []string{"download", "history", "inspect", "prune", "setup", "speedtest"},
+		Features:    buildFeatures(fs),
+	}
+	if c.Version == "dev" {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			c.Version = info.Main.Version
+		}
+	}
+	return c
+}
+
+// printCapabilities writes the capabilities document as indented JSON.
+func printCapabilities(w io.Writer, c capabilities) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRun_Capabilities(t *testing.T) {
+	h := Hooks{LookPath: func(file string) (string, error) {
+		if file == "ffmpeg" {
+			return "/usr/bin/ffmpeg", nil
+		}
+		return "", errors.New("not found")
+	}}
+	stdout := new(bytes.Buffer)
+	if code := run([]string{"cfs-dl", "--capabilities"}, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	var c capabilities
+	if err := json.Unmarshal(stdout.Bytes(), &c); err != nil {
+		t.Fatalf("expected only JSON on stdout, got %q: %v", stdout.String(), err)
+	}
+	if !c.Protocols["dash"] || c.Protocols["hls"] || c.Protocols["live"] {
+		t.Errorf("unexpected protocols %v", c.Protocols)
+	}
+	if len(c.Muxers) != 1 || !c.Muxers[0].Available || c.Muxers[0].Path != "/usr/bin/ffmpeg" {
+		t.Errorf("expected ffmpeg to be available, got %+v", c.Muxers)
+	}
+	for _, tool := range c.Tools {
+		if tool.Available {
+			t.Errorf("expected %s to be missing", tool.Name)
+		}
+	}
+	if !slices.Contains(c.Features, "resume") || !slices.IsSorted(c.Features) {
+		t.Errorf("expected a sorted feature list, got %v", c.Features)
+	}
+	// Features come from the flags, so a new flag can't be left out
+	for _, want := range []string{"strict", "low-memory", "exec", "write-stats", "write-pages", "max-duration", "refetch-missing", "list-formats", "dump-json", "cookies", "header-rules"} {
+		if !slices.Contains(c.Features, want) {
+			t.Errorf("expected feature %q, got %v", want, c.Features)
+		}
+	}
+	for _, basic := range []string{"url", "cookie", "exec-timeout"} {
+		if slices.Contains(c.Features, basic) {
+			t.Errorf("expected no feature %q, got %v", basic, c.Features)
+		}
+	}
+	if c.Version == "" || c.OS == "" {
+		t.Errorf("expected version and platform, got %+v", c)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// exitCodePanic is returned after a recovered panic (EX_SOFTWARE from sysexits.h),
+// so scripts can tell crashes apart from ordinary failures.
+const exitCodePanic = 70
+
+// crashState is the pipeline state included in crash reports.
+type crashState struct {
+	URL            string
+	OutputDir      string
+	Phase          string
+	Representation string
+}
+
+// writeCrashReport writes a crash report for a recovered panic into the output
+// directory (or the temp dir if that doesn't exist yet) and returns its path.
+// segment is the media segment being fetched, or -1 if the panic happened elsewhere.
+func writeCrashReport(state crashState, value any, stack []byte, segment int) (string, error) {
+	dir := state.OutputDir
+	if info, err := os.Stat(dir); dir == "" || err != nil || !info.IsDir() {
+		dir = os.TempDir()
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("cfs-dl-crash-%s.txt", now.Format("20060102-150405")))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, _ = fmt.Fprintf(f, "cfs-dl crash report\n")
+	_, _ = fmt.Fprintf(f, "Time: %s\n", now.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(f, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	_, _ = fmt.Fprintf(f, "URL: %s\n", state.URL)
+	_, _ = fmt.Fprintf(f, "Phase: %s\n", state.Phase)
+	if state.Representation != "" {
+		_, _ = fmt.Fprintf(f, "Representation: %s\n", state.Representation)
+	}
+	if segment >= 0 {
+		_, _ = fmt.Fprintf(f, "Segment: %d\n", segment)
+	}
+	_, _ = fmt.Fprintf(f, "Panic: %v\n\n%s", value, stack)
+
+	return path, nil
+}
+
+// handlePanic reports a recovered panic and returns the exit code for it.
+func handlePanic(stdout io.Writer, state crashState, value any, stack []byte, segment int) int {
+	_, _ = fmt.Fprintf(stdout, "Internal error: %v\n", value)
+	path, err := writeCrashReport(state, value, stack, segment)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Failed to write crash report: %v\n%s", err, stack)
+	} else {
+		_, _ = fmt.Fprintf(stdout, "Crash report written to %s, please include it when reporting this bug.\n", path)
+	}
+	return exitCodePanic
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// execHook is a user command run after a successful download (--exec).
+type execHook struct {
+	Command string
+	Dir     string        // Working directory; empty uses the current one
+	Timeout time.Duration // 0 means no limit
+}
+
+// execJob is the job metadata handed to an exec hook as CFS_* variables.
+type execJob struct {
+	Title    string
+	Path     string
+	URL      string
+	Duration float64
+}
+
+func (j execJob) env() []string {
+	return []string{
+		"CFS_TITLE=" + j.Title,
+		"CFS_PATH=" + j.Path,
+		"CFS_URL=" + j.URL,
+		"CFS_DURATION=" + strconv.FormatFloat(j.Duration, 'f', -1, 64),
+	}
+}
+
+// errExecTimedOut is the cancel cause when hook.Timeout runs out, to tell it
+// apart from the whole run being cancelled or timing out.
+var errExecTimedOut = errors.New("--exec-timeout reached")
+
+// runExecHook runs hook through the platform shell with the job metadata
+// added to the environment. The command's output goes to stdout and stderr.
+func runExecHook(ctx context.Context, hook execHook, job execJob, stdout, stderr io.Writer) error {
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, hook.Timeout, errExecTimedOut)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+	cmd.Dir = hook.Dir
+	cmd.Env = append(os.Environ(), job.env()...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Don't wait forever on pipes held open by background children after a kill
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if errors.Is(context.Cause(ctx), errExecTimedOut) {
+		return fmt.Errorf("timed out after %s", hook.Timeout)
+	}
+	return err
+}
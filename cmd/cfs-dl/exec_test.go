@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunExecHook(t *testing.T) {
+	dir := t.TempDir()
+	hook := execHook{Command: `printf '%s|%s|%s|%s' "$CFS_TITLE" "$CFS_PATH" "$CFS_URL" "$CFS_DURATION" > env.txt`, Dir: dir}
+	job := execJob{Title: "My Video", Path: "/videos/My Video.mp4", URL: "https://example.com/abc/iframe", Duration: 90.5}
+
+	if err := runExecHook(context.Background(), hook, job, new(bytes.Buffer), new(bytes.Buffer)); err != nil {
+		t.Fatalf("runExecHook failed: %v", err)
+	}
+
+	// The command ran in Dir, so the relative path landed there
+	data, err := os.ReadFile(filepath.Join(dir, "env.txt"))
+	if err != nil {
+		t.Fatalf("expected output in the working directory: %v", err)
+	}
+	want := "My Video|/videos/My Video.mp4|https://example.com/abc/iframe|90.5"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestRunExecHook_Timeout(t *testing.T) {
+	hook := execHook{Command: "sleep 10", Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	err := runExecHook(context.Background(), hook, execJob{}, new(bytes.Buffer), new(bytes.Buffer))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook was not killed on timeout, took %v", elapsed)
+	}
+}
+
+func TestRunExecHook_RunTimeout(t *testing.T) {
+	// The run's own --timeout isn't the hook's fault, with or without --exec-timeout
+	for _, limit := range []time.Duration{0, time.Minute} {
+		ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, errTimedOut)
+		err := runExecHook(ctx, execHook{Command: "sleep 10", Timeout: limit}, execJob{}, new(bytes.Buffer), new(bytes.Buffer))
+		cancel()
+		if err == nil || strings.Contains(err.Error(), "timed out after") {
+			t.Errorf("expected the command's own error with --exec-timeout %s, got %v", limit, err)
+		}
+	}
+}
+
+func TestRunExecHook_Fail(t *testing.T) {
+	if err := runExecHook(context.Background(), execHook{Command: "exit 3"}, execJob{}, new(bytes.Buffer), new(bytes.Buffer)); err == nil {
+		t.Error("expected error for a failing command, got nil")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// videoInfo is the document printed by --dump-json. Times are pointers so
+// absent ones are omitted rather than printed as the zero time.
+type videoInfo struct {
+	URL                   string                     `json:"url"`
+	ManifestURL           string                     `json:"manifest_url"`
+	VideoUID              string                     `json:"video_uid"`
+	Title                 string                     `json:"title,omitempty"`
+	Duration              float64                    `json:"duration"`
+	Type                  string                     `json:"type"`
+	AvailabilityStartTime *time.Time                 `json:"availability_start_time,omitempty"`
+	PublishTime           *time.Time                 `json:"publish_time,omitempty"`
+	Formats               []model.RepresentationInfo `json:"formats"`
+}
+
+func newVideoInfo(url, manifestUrl string, mpd *model.MPD) videoInfo {
+	info := videoInfo{
+		URL:         url,
+		ManifestURL: manifestUrl,
+		VideoUID:    extractVideoUID(url),
+		Duration:    mpd.DurationSeconds(),
+		Type:        mpd.PresentationType(),
+		Formats:     mpd.ListRepresentations(),
+	}
+	if mpd.ProgramInformation != nil {
+		info.Title = mpd.ProgramInformation.Title
+	}
+	if t, ok := mpd.AvailabilityStart(); ok {
+		info.AvailabilityStartTime = &t
+	}
+	if t, ok := mpd.Published(); ok {
+		info.PublishTime = &t
+	}
+	return info
+}
+
+// loadVideoInfo reads a document written by --dump-json.
+func loadVideoInfo(path string) (videoInfo, error) {
+	var info videoInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if info.ManifestURL == "" {
+		return info, fmt.Errorf("%s has no manifest_url", path)
+	}
+	return info, nil
+}
+
+// manifest rebuilds enough of the MPD from a saved info document to select
+// and download streams without fetching the manifest again.
+func (info videoInfo) manifest() (*model.MPD, error) {
+	sets, err := model.AdaptationSetsFromList(info.Formats)
+	if err != nil {
+		return nil, fmt.Errorf("%w (re-dump the info with this version of cfs-dl)", err)
+	}
+
+	mpd := &model.MPD{
+		Type:                      info.Type,
+		MediaPresentationDuration: "PT" + strconv.FormatFloat(info.Duration, 'f', -1, 64) + "S",
+		Period:                    model.Period{AdaptationSets: sets},
+	}
+	if info.Title != "" {
+		mpd.ProgramInformation = &model.ProgramInformation{Title: info.Title}
+	}
+	if info.AvailabilityStartTime != nil {
+		mpd.AvailabilityStartTime = info.AvailabilityStartTime.Format(time.RFC3339Nano)
+	}
+	if info.PublishTime != nil {
+		mpd.PublishTime = info.PublishTime.Format(time.RFC3339Nano)
+	}
+	return mpd, nil
+}
+
+func dumpJSON(w io.Writer, info videoInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+// segmentList is one selected stream in the --dump-segments output.
+type segmentList struct {
+	Kind             string   `json:"kind"`
+	RepresentationID string   `json:"representation_id"`
+	Init             string   `json:"init"`
+	Media            []string `json:"media"`
+}
+
+// segmentLists resolves the segment URLs of the video and audio streams cfg selects.
+func segmentLists(mpd *model.MPD, manifestUrl string, cfg Config) ([]segmentList, error) {
+	var lists []segmentList
+	for _, kind := range []string{"video", "audio"} {
+		policy := cfg.Video
+		if kind == "audio" {
+			policy = cfg.Audio
+		}
+		rep, err := mpd.Select(kind, policy)
+		if err != nil {
+			return nil, err
+		}
+		init, media, err := downloader.SegmentURLs(manifestUrl, rep, mpd.DurationSeconds(), cfg.QueryMode)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, segmentList{Kind: kind, RepresentationID: rep.ID, Init: init, Media: media})
+	}
+	return lists, nil
+}
+
+// printSegments writes the --dump-segments output: one URL per line in
+// download order (init segment first, video before audio), or JSON.
+func printSegments(w io.Writer, format string, lists []segmentList) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(lists)
+	}
+	for _, l := range lists {
+		if _, err := fmt.Fprintln(w, l.Init); err != nil {
+			return err
+		}
+		for _, u := range l.Media {
+			if _, err := fmt.Fprintln(w, u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printFormats writes the --list-formats table.
+func printFormats(w io.Writer, formats []model.RepresentationInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tKIND\tRESOLUTION\tFPS\tCODEC\tLANG\tBANDWIDTH\tSIZE")
+	for _, f := range formats {
+		resolution, fps := "-", "-"
+		if f.Height > 0 {
+			resolution = fmt.Sprintf("%dx%d", f.Width, f.Height)
+		}
+		if f.FPS > 0 {
+			fps = fmt.Sprintf("%.3g", f.FPS)
+		}
+		lang := f.Lang
+		if lang == "" {
+			lang = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%dk\t~%s\n",
+			f.ID, f.Kind, resolution, fps, f.Codec, lang, f.Bandwidth/1000, formatBytes(f.EstimatedSize))
+	}
+	_ = tw.Flush()
+}
+
+// formatBytes renders n with a binary unit, e.g. "12.3 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"cfs-dl/internal/model"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testFormatsMPD() *model.MPD {
+	return &model.MPD{
+		MediaPresentationDuration: "PT1M0S",
+		PublishTime:               "2024-05-01T12:00:00Z",
+		ProgramInformation:        &model.ProgramInformation{Title: "Demo"},
+		Period: model.Period{
+			AdaptationSets: []model.AdaptationSet{
+				{MimeType: "video/mp4", Representations: []model.Representation{
+					{ID: "v1", Width: 1920, Height: 1080, Bandwidth: 4000000, Codecs: "avc1.640028", FrameRate: "30"},
+				}},
+				{MimeType: "audio/mp4", Lang: "en", Representations: []model.Representation{
+					{ID: "a1", Bandwidth: 128000, Codecs: "mp4a.40.2"},
+				}},
+			},
+		},
+	}
+}
+
+func TestPrintFormats(t *testing.T) {
+	out := new(bytes.Buffer)
+	printFormats(out, testFormatsMPD().ListRepresentations())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", out.String())
+	}
+	for _, want := range []string{"v1", "1920x1080", "avc1.640028", "4000k", "~28.6 MiB"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("expected %q in video row %q", want, lines[1])
+		}
+	}
+	if !strings.Contains(lines[2], "en") {
+		t.Errorf("expected language in audio row %q", lines[2])
+	}
+}
+
+func TestDumpJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	info := newVideoInfo("https://example.com/abc/iframe", "https://example.com/abc/manifest/video.mpd", testFormatsMPD())
+	if err := dumpJSON(out, info); err != nil {
+		t.Fatalf("dumpJSON failed: %v", err)
+	}
+
+	var got videoInfo
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if got.Title != "Demo" || got.Duration != 60 || got.VideoUID != "abc" || len(got.Formats) != 2 {
+		t.Errorf("unexpected info %+v", got)
+	}
+	if got.Type != "static" || got.PublishTime == nil || got.PublishTime.Year() != 2024 || got.AvailabilityStartTime != nil {
+		t.Errorf("unexpected presentation attributes %+v", got)
+	}
+	if strings.Contains(out.String(), "availability_start_time") {
+		t.Errorf("expected absent availability start to be omitted, got %s", out.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{500, "500 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.input); got != tt.expected {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"cfs-dl/internal/config"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runHistory implements "cfs-dl history", which lists past downloads from the
+// .stats.json files that --write-stats leaves next to each output.
+func runHistory(prog string, args []string, stdout, stderr io.Writer, hooks Hooks) int {
+	flags := flag.NewFlagSet(prog+" history", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	exportPtr := flags.String("export", "", "Print the records as \"csv\" or \"json\" instead of a table")
+	configPtr := flags.String("config", "", "Path to a JSON config file whose output_dir is searched (default: "+config.DefaultPath()+")")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: %s history [--export csv|json] [dir...]\n", prog)
+		_, _ = fmt.Fprintf(stderr, "\nLists downloads made with --write-stats found under the given directories (default: the configured output directory).\n")
+		_, _ = fmt.Fprintf(stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if *exportPtr != "" && *exportPtr != "csv" && *exportPtr != "json" {
+		_, _ = fmt.Fprintf(stdout, "Error: invalid --export format %q, expected csv or json\n", *exportPtr)
+		return 1
+	}
+
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		cfgPath, cfgRequired := *configPtr, true
+		if cfgPath == "" {
+			cfgPath, cfgRequired = config.DefaultPath(), false
+		}
+		fileCfg, err := config.Load(cfgPath, cfgRequired)
+		if err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error loading config: %v\n", err)
+			return 1
+		}
+		dirs = []string{historyRoot(fileCfg.OutputDir)}
+	}
+
+	records, err := loadHistory(dirs, stderr)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error reading history: %v\n", err)
+		return 1
+	}
+
+	switch *exportPtr {
+	case "csv":
+		err = writeHistoryCSV(stdout, records)
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(records)
+	default:
+		printHistory(stdout, records)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error writing history: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// historyRoot returns the directory to search for an --output-dir value: the
+// part before the first placeholder, so "archive/{uid}" searches "archive".
+func historyRoot(outputDir string) string {
+	if outputDir == "" {
+		return "data/download"
+	}
+	if i := strings.Index(outputDir, "{"); i >= 0 {
+		// The stand-in keeps Dir from stopping at a trailing slash
+		return filepath.Dir(outputDir[:i] + "x")
+	}
+	return outputDir
+}
+
+// historyRecord is one past download, flattened for reports.
+type historyRecord struct {
+	FinishedAt         time.Time `json:"finished_at"`
+	Title              string    `json:"title,omitempty"`
+	URL                string    `json:"url"`
+	VideoUID           string    `json:"video_uid"`
+	Output             string    `json:"output"`
+	Size               int64     `json:"size"`     // Bytes on disk now, or downloaded if the output is gone
+	Duration           float64   `json:"duration"` // Seconds of video
+	DownloadSeconds    float64   `json:"download_seconds"`
+	AverageBytesPerSec float64   `json:"average_bytes_per_sec"`
+	Video              []string  `json:"video,omitempty"` // Representation IDs
+	Audio              []string  `json:"audio,omitempty"`
+	StatsFile          string    `json:"stats_file"`
+}
+
+// loadHistory reads every .stats.json under dirs, oldest download first.
+// Missing directories are skipped so a fresh install has an empty history,
+// and stats files that can't be read are skipped with a warning on warnOut.
+func loadHistory(dirs []string, warnOut io.Writer) ([]historyRecord, error) {
+	records := []historyRecord{}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".stats.json") {
+				return nil
+			}
+			rec, err := readHistoryRecord(path)
+			if err != nil {
+				_, _ = fmt.Fprintf(warnOut, "Warning: skipping %v\n", err)
+				return nil
+			}
+			records = append(records, rec)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].FinishedAt.Before(records[j].FinishedAt)
+	})
+	return records, nil
+}
+
+func readHistoryRecord(path string) (historyRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return historyRecord{}, err // Already names the file
+	}
+	var s downloadStats
+	if err := json.Unmarshal(data, &s); err != nil {
+		return historyRecord{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	rec := historyRecord{
+		FinishedAt:         s.FinishedAt,
+		Title:              s.Title,
+		URL:                s.URL,
+		VideoUID:           s.VideoUID,
+		Output:             s.Output,
+		Duration:           s.Duration,
+		DownloadSeconds:    s.FinishedAt.Sub(s.StartedAt).Seconds(),
+		AverageBytesPerSec: s.AverageBytesPerSec,
+		StatsFile:          path,
+	}
+	for _, st := range s.Streams {
+		rec.Size += st.Bytes
+		switch st.Kind {
+		case "video":
+			rec.Video = append(rec.Video, st.RepresentationID)
+		case "audio":
+			rec.Audio = append(rec.Audio, st.RepresentationID)
+		}
+	}
+	if info, err := os.Stat(rec.Output); err == nil && !info.IsDir() {
+		rec.Size = info.Size()
+	}
+	return rec, nil
+}
+
+// writeHistoryCSV writes one row per download with a header row, in units
+// spreadsheets can sum: bytes and seconds.
+func writeHistoryCSV(w io.Writer, records []historyRecord) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"finished_at", "title", "url", "video_uid", "output", "size_bytes", "duration_seconds", "download_seconds", "average_bytes_per_sec", "video", "audio"})
+	for _, r := range records {
+		_ = cw.Write([]string{
+			r.FinishedAt.Format(time.RFC3339),
+			r.Title,
+			r.URL,
+			r.VideoUID,
+			r.Output,
+			strconv.FormatInt(r.Size, 10),
+			strconv.FormatFloat(r.Duration, 'f', 3, 64),
+			strconv.FormatFloat(r.DownloadSeconds, 'f', 3, 64),
+			strconv.FormatFloat(r.AverageBytesPerSec, 'f', 0, 64),
+			strings.Join(r.Video, ";"),
+			strings.Join(r.Audio, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printHistory writes the history table and a totals line.
+func printHistory(w io.Writer, records []historyRecord) {
+	if len(records) == 0 {
+		_, _ = fmt.Fprintln(w, "No downloads found. Only downloads made with --write-stats are recorded.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FINISHED\tDURATION\tSIZE\tTITLE\tOUTPUT")
+	var size int64
+	var duration float64
+	for _, r := range records {
+		size += r.Size
+		duration += r.Duration
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			r.FinishedAt.Local().Format("2006-01-02 15:04"), formatSeconds(r.Duration), formatBytes(r.Size), dash(r.Title), r.Output)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "\n%d downloads, %s of video, %s\n", len(records), formatSeconds(duration), formatBytes(size))
+}
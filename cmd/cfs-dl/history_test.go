@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHistoryStats writes a stats file for a finished download of name into dir.
+func writeHistoryStats(t *testing.T, dir, name string, finished time.Time, keepOutput bool) {
+	t.Helper()
+	output := filepath.Join(dir, name+".mp4")
+	if keepOutput {
+		if err := os.WriteFile(output, make([]byte, 5000), 0644); err != nil {
+			t.Fatalf("failed to write output: %v", err)
+		}
+	}
+	stats := &downloadStats{
+		URL:        "https://example.com/" + name + "/iframe",
+		VideoUID:   name,
+		Title:      "Video " + name,
+		Output:     output,
+		Duration:   90,
+		StartedAt:  finished.Add(-10 * time.Second),
+		FinishedAt: finished,
+		Streams: []streamStats{
+			{Kind: "video", RepresentationID: "1080p", Bytes: 3000},
+			{Kind: "audio", RepresentationID: "audio", Bytes: 1000},
+		},
+	}
+	if err := stats.write(filepath.Join(dir, name+".stats.json")); err != nil {
+		t.Fatalf("failed to write stats: %v", err)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "nested")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	writeHistoryStats(t, sub, "second", day.Add(time.Hour), false)
+	writeHistoryStats(t, dir, "first", day, true)
+	_ = os.WriteFile(filepath.Join(dir, "notes.json"), []byte("{"), 0644)
+
+	t.Run("CSV", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "history", "--export", "csv", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		rows, err := csv.NewReader(stdout).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v", err)
+		}
+		if len(rows) != 3 || rows[0][0] != "finished_at" {
+			t.Fatalf("expected a header and 2 rows, got %q", rows)
+		}
+		// Oldest first; the size is the file on disk while it exists
+		expected := []string{"2026-03-01T12:00:00Z", "Video first", "https://example.com/first/iframe", "first", filepath.Join(dir, "first.mp4"), "5000", "90.000", "10.000", "0", "1080p", "audio"}
+		if strings.Join(rows[1], ",") != strings.Join(expected, ",") {
+			t.Errorf("expected row %q, got %q", expected, rows[1])
+		}
+		if rows[2][3] != "second" || rows[2][5] != "4000" {
+			t.Errorf("expected the downloaded size for a deleted output, got %q", rows[2])
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "history", "--export", "json", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		var records []historyRecord
+		if err := json.Unmarshal(stdout.Bytes(), &records); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(records) != 2 || records[1].VideoUID != "second" {
+			t.Errorf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("Table", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "history", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		if !strings.Contains(stdout.String(), "2 downloads, 3m0s of video, 8.8 KiB") {
+			t.Errorf("unexpected totals in:\n%s", stdout.String())
+		}
+	})
+}
+
+func TestRunHistory_Empty(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "history", filepath.Join(t.TempDir(), "missing")}
+	if code := run(args, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "No downloads found") {
+		t.Errorf("expected an empty history, got %s", stdout.String())
+	}
+}
+
+func TestRunHistory_Malformed(t *testing.T) {
+	dir := t.TempDir()
+	writeHistoryStats(t, dir, "first", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), true)
+	broken := filepath.Join(dir, "broken.stats.json")
+	if err := os.WriteFile(broken, []byte(`{"title": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if code := run([]string{"cfs-dl", "history", "--export", "json", dir}, stdout, stderr, Hooks{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	var records []historyRecord
+	if err := json.Unmarshal(stdout.Bytes(), &records); err != nil {
+		t.Fatalf("expected only JSON on stdout: %v", err)
+	}
+	if len(records) != 1 || records[0].VideoUID != "first" {
+		t.Errorf("expected the readable download, got %+v", records)
+	}
+	if !strings.Contains(stderr.String(), "Warning: skipping "+broken) {
+		t.Errorf("expected a warning naming the broken file, got %q", stderr.String())
+	}
+}
+
+func TestRunHistory_BadFormat(t *testing.T) {
+	if code := run([]string{"cfs-dl", "history", "--export", "xlsx"}, new(bytes.Buffer), new(bytes.Buffer), Hooks{}); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+}
+
+func TestHistoryRoot(t *testing.T) {
+	tests := map[string]string{
+		"":                            "data/download",
+		"videos":                      "videos",
+		"archive/{customer_domain}/x": "archive",
+		"archive/prefix-{uid}":        "archive",
+		"{uid}":                       ".",
+	}
+	for in, want := range tests {
+		if got := historyRoot(in); got != want {
+			t.Errorf("historyRoot(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cfs-dl/internal/merger"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// runInspect implements "cfs-dl inspect", which shows what ffprobe sees in
+// already downloaded files, e.g. to audit an old archive.
+func runInspect(prog string, args []string, stdout, stderr io.Writer, hooks Hooks) int {
+	fs := flag.NewFlagSet(prog+" inspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonPtr := fs.Bool("json", false, "Print the details as a JSON array instead of tables")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: %s inspect [--json] <file>...\n", prog)
+		_, _ = fmt.Fprintf(stderr, "\nShows the streams, codecs, duration and chapters of downloaded files using ffprobe.\n")
+		_, _ = fmt.Fprintf(stderr, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		_, _ = fmt.Fprintln(stdout, "Error: no files given")
+		fs.Usage()
+		return 1
+	}
+
+	code := 0
+	infos := make([]*merger.FileInfo, 0, fs.NArg())
+	for _, file := range fs.Args() {
+		info, err := hooks.Inspect(file)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error inspecting %s: %v\n", file, err)
+			code = 1
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if *jsonPtr {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(infos); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error writing JSON: %v\n", err)
+			return 1
+		}
+		return code
+	}
+	for i, info := range infos {
+		if i > 0 {
+			_, _ = fmt.Fprintln(stdout)
+		}
+		printFileInfo(stdout, info)
+	}
+	return code
+}
+
+// printFileInfo writes the inspect tables for one file.
+func printFileInfo(w io.Writer, info *merger.FileInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "File:\t%s\n", info.Path)
+	_, _ = fmt.Fprintf(tw, "Format:\t%s\n", info.Format)
+	_, _ = fmt.Fprintf(tw, "Duration:\t%s\n", formatSeconds(info.Duration))
+	_, _ = fmt.Fprintf(tw, "Size:\t%s\n", formatBytes(info.Size))
+	_, _ = fmt.Fprintf(tw, "Bit rate:\t%dk\n", info.BitRate/1000)
+	keys := make([]string, 0, len(info.Tags))
+	for k := range info.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(tw, "Tag %s:\t%s\n", k, info.Tags[k])
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "INDEX\tKIND\tCODEC\tPROFILE\tRESOLUTION\tFPS\tCHANNELS\tLANG\tBITRATE")
+	for _, s := range info.Streams {
+		profile, resolution, fps, channels, lang, bitrate := dash(s.Profile), "-", "-", "-", dash(s.Lang), "-"
+		if s.Height > 0 {
+			resolution = fmt.Sprintf("%dx%d", s.Width, s.Height)
+		}
+		if s.FPS > 0 {
+			fps = fmt.Sprintf("%.4g", s.FPS) // Keeps 29.97 from showing as 30
+		}
+		if s.Channels > 0 {
+			channels = fmt.Sprintf("%d @ %d Hz", s.Channels, s.SampleRate)
+		}
+		if s.BitRate > 0 {
+			bitrate = fmt.Sprintf("%dk", s.BitRate/1000)
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Index, s.Kind, s.Codec, profile, resolution, fps, channels, lang, bitrate)
+	}
+	_ = tw.Flush()
+
+	if len(info.Chapters) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CHAPTER\tSTART\tEND\tTITLE")
+	for i, c := range info.Chapters {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, formatSeconds(c.Start), formatSeconds(c.End), dash(c.Title))
+	}
+	_ = tw.Flush()
+}
+
+// formatSeconds renders a duration in seconds to the millisecond, e.g. "1m0.01s".
+func formatSeconds(secs float64) string {
+	return time.Duration(secs * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -2,13 +2,72 @@ package main
 
 import (
 	"bytes"
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/httpclient"
 	"cfs-dl/internal/model"
+	"cfs-dl/internal/progress"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the developer's own config (and resume cache) out of the tests
+	home, err := os.MkdirTemp("", "cfs-dl-test-home-")
+	if err != nil {
+		panic(err)
+	}
+	for _, env := range []string{"HOME", "XDG_CONFIG_HOME", "XDG_CACHE_HOME", "AppData", "LocalAppData"} {
+		_ = os.Setenv(env, home)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// simpleMPD returns a manifest with one 1080p video and one audio
+// representation, the pair most run tests download.
+func simpleMPD(audioID string) *model.MPD {
+	return &model.MPD{
+		Period: model.Period{
+			AdaptationSets: []model.AdaptationSet{
+				{MimeType: "video/mp4", Representations: []model.Representation{{ID: "1080p", Height: 1080}}},
+				{MimeType: "audio/mp4", Representations: []model.Representation{{ID: audioID}}},
+			},
+		},
+	}
+}
+
+// segmentedMPD is simpleMPD lasting duration, with both representations
+// split into segments by tmpl.
+func segmentedMPD(audioID, duration string, tmpl model.SegmentTemplate) *model.MPD {
+	mpd := simpleMPD(audioID)
+	mpd.MediaPresentationDuration = duration
+	for i := range mpd.Period.AdaptationSets {
+		mpd.Period.AdaptationSets[i].Representations[0].SegmentTemplate = tmpl
+	}
+	return mpd
+}
+
+// testHooks fakes the steps that would otherwise hit the network, since the
+// run tests use made-up manifests. Tests override the rest as needed.
+func testHooks() Hooks {
+	return Hooks{
+		PrefetchInit: func(ctx context.Context, base string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error) {
+			return make([][]byte, len(reps)), nil
+		},
+	}
+}
+
 func TestSanitizeFilename(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -50,40 +109,107 @@ func TestParseResolution(t *testing.T) {
 	}
 }
 
-func TestParseDuration(t *testing.T) {
+func TestExtractManifestUrl(t *testing.T) {
 	tests := []struct {
 		input    string
-		expected float64
+		expected string
 	}{
-		{"PT1M30S", 90.0},
-		{"PT45S", 45.0},
+		{"https://example.com/video/iframe", "https://example.com/video/manifest/video.mpd"},
+		{"https://example.com/video.mpd", "https://example.com/video.mpd"},
+		{"https://example.com/video", "https://example.com/video/manifest/video.mpd"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			got, _ := parseDuration(tt.input)
+			got, _ := extractManifestUrl(tt.input)
 			if got != tt.expected {
-				t.Errorf("parseDuration(%q) = %f, want %f", tt.input, got, tt.expected)
+				t.Errorf("extractManifestUrl(%q) = %q, want %q", tt.input, got, tt.expected)
 			}
 		})
 	}
 }
 
-func TestExtractManifestUrl(t *testing.T) {
+func TestExtractVideoUID(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
-		{"https://example.com/video/iframe", "https://example.com/video/manifest/video.mpd"},
-		{"https://example.com/video.mpd", "https://example.com/video.mpd"},
-		{"https://example.com/video", "https://example.com/video/manifest/video.mpd"},
+		{"https://customer-xyz.cloudflarestream.com/0123456789abcdef0123456789abcdef/iframe", "0123456789abcdef0123456789abcdef"},
+		{"https://customer-xyz.cloudflarestream.com/0123456789abcdef0123456789abcdef/manifest/video.mpd", "0123456789abcdef0123456789abcdef"},
+		{"https://iframe.videodelivery.net/0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef"},
+		{"https://example.com/video/iframe", "video"},
+		{"https://example.com", ""},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			got, _ := extractManifestUrl(tt.input)
+			got := extractVideoUID(tt.input)
 			if got != tt.expected {
-				t.Errorf("extractManifestUrl(%q) = %q, want %q", tt.input, got, tt.expected)
+				t.Errorf("extractVideoUID(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWorkDirFor(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	base, err := os.UserCacheDir()
+	if err != nil {
+		t.Skipf("no user cache dir: %v", err)
+	}
+
+	uid := "0123456789abcdef0123456789abcdef"
+	got := workDirFor("https://customer-xyz.cloudflarestream.com/" + uid + "/iframe")
+	if got != filepath.Join(base, "cfs-dl", "resume", uid) {
+		t.Errorf("unexpected work dir %q", got)
+	}
+
+	token := strings.Repeat("eyJhbGciOiJSUzI1NiJ9", 10)
+	got = workDirFor("https://customer-xyz.cloudflarestream.com/" + token + "/iframe")
+	if len(filepath.Base(got)) != 32 {
+		t.Errorf("expected long tokens to be hashed, got %q", got)
+	}
+
+	// Tokens issued at different times for the same video share a work dir
+	jwt := func(exp int) string {
+		claims := fmt.Sprintf(`{"sub":%q,"kid":"k","exp":%d}`, uid, exp)
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+	first := workDirFor("https://customer-xyz.cloudflarestream.com/" + jwt(1700000000) + "/iframe")
+	second := workDirFor("https://customer-xyz.cloudflarestream.com/" + jwt(1800000000) + "/manifest/video.mpd")
+	if filepath.Base(first) != uid || first != second {
+		t.Errorf("expected signed URLs to be keyed by the video UID, got %q and %q", first, second)
+	}
+
+	if got := workDirFor("https://example.com"); got != "" {
+		t.Errorf("expected no work dir without a UID, got %q", got)
+	}
+}
+
+func TestExpandOutputTemplate(t *testing.T) {
+	uid := "0123456789abcdef0123456789abcdef"
+	iframe := "https://Customer-XYZ.cloudflarestream.com/" + uid + "/iframe"
+	tests := []struct {
+		name     string
+		tmpl     string
+		url      string
+		title    string
+		expected string
+	}{
+		{"No placeholders", "data/download", iframe, "Talk", "data/download"},
+		{"All placeholders", "archive/{customer_domain}/{uid}/{title}", iframe, "Talk: Part 1", "archive/customer-xyz.cloudflarestream.com/" + uid + "/Talk- Part 1"},
+		{"Title falls back to UID", "{title}.mp4", iframe, "", uid + ".mp4"},
+		{"Slashes stay in one directory", "out/{title}", iframe, "../../etc", "out/..-..-etc"},
+		{"Dot-dot title", "out/{title}", iframe, "..", "out/unknown"},
+		{"No host", "out/{customer_domain}", "not a url", "", "out/unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandOutputTemplate(tt.tmpl, tt.url, tt.title); got != tt.expected {
+				t.Errorf("expandOutputTemplate(%q) = %q, want %q", tt.tmpl, got, tt.expected)
 			}
 		})
 	}
@@ -95,7 +221,7 @@ func TestRun_CheckDependencies(t *testing.T) {
 	stderr := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--check-dependencies"}
 
-	code := run(args, stdout, stderr)
+	code := run(args, stdout, stderr, Hooks{})
 	if code != 0 {
 		t.Errorf("expected exit code 0, got %d", code)
 	}
@@ -109,7 +235,7 @@ func TestRun_NoUrl(t *testing.T) {
 	stderr := new(bytes.Buffer)
 	args := []string{"cfs-dl"}
 
-	code := run(args, stdout, stderr)
+	code := run(args, stdout, stderr, Hooks{})
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -119,16 +245,14 @@ func TestRun_NoUrl(t *testing.T) {
 }
 
 func TestRun_ParseManifestFail(t *testing.T) {
-	orig := parseManifestFunc
-	defer func() { parseManifestFunc = orig }()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
 		return nil, fmt.Errorf("mock parse error")
 	}
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe"}
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -138,10 +262,8 @@ func TestRun_ParseManifestFail(t *testing.T) {
 }
 
 func TestRun_StreamSelectFail(t *testing.T) {
-	origParse := parseManifestFunc
-	defer func() { parseManifestFunc = origParse }()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
 		// Return MPD with NO video representations
 		return &model.MPD{
 			Period: model.Period{
@@ -154,7 +276,7 @@ func TestRun_StreamSelectFail(t *testing.T) {
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe"}
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -164,41 +286,18 @@ func TestRun_StreamSelectFail(t *testing.T) {
 }
 
 func TestRun_DownloadFail(t *testing.T) {
-	origParse := parseManifestFunc
-	origDL := downloadStreamFunc
-	defer func() {
-		parseManifestFunc = origParse
-		downloadStreamFunc = origDL
-	}()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
-		return &model.MPD{
-			Period: model.Period{
-				AdaptationSets: []model.AdaptationSet{
-					{
-						MimeType: "video/mp4",
-						Representations: []model.Representation{
-							{ID: "1080p", Height: 1080, Bandwidth: 1000},
-						},
-					},
-					{
-						MimeType: "audio/mp4",
-						Representations: []model.Representation{
-							{ID: "audio", Bandwidth: 100},
-						},
-					},
-				},
-			},
-		}, nil
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
 	}
 
-	downloadStreamFunc = func(ctx context.Context, base string, rep *model.Representation, dur float64) (string, error) {
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
 		return "", fmt.Errorf("mock download error")
 	}
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe"}
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -208,47 +307,25 @@ func TestRun_DownloadFail(t *testing.T) {
 }
 
 func TestRun_MergeFail(t *testing.T) {
-	origParse := parseManifestFunc
-	origDL := downloadStreamFunc
-	origMerge := mergeAudioVideoFunc
-	defer func() {
-		parseManifestFunc = origParse
-		downloadStreamFunc = origDL
-		mergeAudioVideoFunc = origMerge
-	}()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
-		return &model.MPD{
-			Period: model.Period{
-				AdaptationSets: []model.AdaptationSet{
-					{
-						MimeType: "video/mp4",
-						Representations: []model.Representation{
-							{ID: "1080p", Height: 1080, Bandwidth: 1000},
-						},
-					},
-					{
-						MimeType: "audio/mp4",
-						Representations: []model.Representation{
-							{ID: "audio", Bandwidth: 100},
-						},
-					},
-				},
-			},
-		}, nil
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
 	}
 
-	downloadStreamFunc = func(ctx context.Context, base string, rep *model.Representation, dur float64) (string, error) {
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
 		return "temp.mp4", nil
 	}
 
-	mergeAudioVideoFunc = func(v, a, o string) error {
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
 		return fmt.Errorf("mock merge error")
 	}
+	h.RemuxAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return fmt.Errorf("mock remux error")
+	}
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe"}
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -258,42 +335,20 @@ func TestRun_MergeFail(t *testing.T) {
 }
 
 func TestRun_Success(t *testing.T) {
-	origParse := parseManifestFunc
-	origDL := downloadStreamFunc
-	origMerge := mergeAudioVideoFunc
-	defer func() {
-		parseManifestFunc = origParse
-		downloadStreamFunc = origDL
-		mergeAudioVideoFunc = origMerge
-	}()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
-		return &model.MPD{
-			Period: model.Period{
-				AdaptationSets: []model.AdaptationSet{
-					{
-						MimeType: "video/mp4",
-						Representations: []model.Representation{
-							{ID: "1080p", Height: 1080, Bandwidth: 1000},
-						},
-					},
-					{
-						MimeType: "audio/mp4",
-						Representations: []model.Representation{
-							{ID: "audio", Bandwidth: 100},
-						},
-					},
-				},
-			},
-			ProgramInformation: &model.ProgramInformation{Title: "TestTitle"},
-		}, nil
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		mpd := simpleMPD("audio")
+		mpd.ProgramInformation = &model.ProgramInformation{Title: "TestTitle"}
+		return mpd, nil
 	}
 
-	downloadStreamFunc = func(ctx context.Context, base string, rep *model.Representation, dur float64) (string, error) {
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
 		return "temp.mp4", nil
 	}
 
-	mergeAudioVideoFunc = func(v, a, o string) error {
+	var gotMeta map[string]string
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		gotMeta = meta
 		return nil
 	}
 
@@ -301,27 +356,31 @@ func TestRun_Success(t *testing.T) {
 	// Use temp dir for output to avoid permission issues or clutter
 	tmpDir := t.TempDir()
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", tmpDir}
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 0 {
 		t.Errorf("expected exit code 0, got %d", code)
 	}
 	if !strings.Contains(stdout.String(), "Successfully created") {
 		t.Errorf("expected success message, got %s", stdout.String())
 	}
+	if !strings.Contains(stdout.String(), "Timings: manifest ") || !strings.Contains(stdout.String(), "merge ") {
+		t.Errorf("expected phase timings in summary, got %s", stdout.String())
+	}
+	if gotMeta["comment"] != "source=https://example.com/iframe uid=iframe" {
+		t.Errorf("expected source metadata, got %v", gotMeta)
+	}
 }
 
 func TestRun_CheckDepsFail(t *testing.T) {
-	origLookPath := lookPathFunc
-	defer func() { lookPathFunc = origLookPath }()
-
-	lookPathFunc = func(file string) (string, error) {
+	h := testHooks()
+	h.LookPath = func(file string) (string, error) {
 		return "", fmt.Errorf("mock found error")
 	}
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--check-dependencies"}
 
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -331,28 +390,9 @@ func TestRun_CheckDepsFail(t *testing.T) {
 }
 
 func TestRun_MkdirFail(t *testing.T) {
-	origParse := parseManifestFunc
-	defer func() { parseManifestFunc = origParse }()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
-		return &model.MPD{
-			Period: model.Period{
-				AdaptationSets: []model.AdaptationSet{
-					{
-						MimeType: "video/mp4",
-						Representations: []model.Representation{
-							{ID: "1080p", Height: 1080, Bandwidth: 1000},
-						},
-					},
-					{
-						MimeType: "audio/mp4",
-						Representations: []model.Representation{
-							{ID: "audio", Bandwidth: 100},
-						},
-					},
-				},
-			},
-		}, nil
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
 	}
 
 	stdout := new(bytes.Buffer)
@@ -360,7 +400,7 @@ func TestRun_MkdirFail(t *testing.T) {
 	// On linux /dev/null/output is a good candidate since /dev/null is a file
 	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", "/dev/null/output"}
 
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 1 {
 		t.Errorf("expected exit code 1, got %d", code)
 	}
@@ -370,32 +410,19 @@ func TestRun_MkdirFail(t *testing.T) {
 }
 
 func TestRun_DownloadCancel(t *testing.T) {
-	origParse := parseManifestFunc
-	origDL := downloadStreamFunc
-	defer func() {
-		parseManifestFunc = origParse
-		downloadStreamFunc = origDL
-	}()
-
-	parseManifestFunc = func(url string) (*model.MPD, error) {
-		return &model.MPD{
-			Period: model.Period{
-				AdaptationSets: []model.AdaptationSet{
-					{MimeType: "video/mp4", Representations: []model.Representation{{ID: "1080p", Height: 1080}}},
-					{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "a", Bandwidth: 100}}},
-				},
-			},
-		}, nil
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
 	}
 
-	downloadStreamFunc = func(ctx context.Context, base string, rep *model.Representation, dur float64) (string, error) {
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
 		return "", context.Canceled
 	}
 
 	stdout := new(bytes.Buffer)
 	args := []string{"cfs-dl", "--url", "https://example.com"}
 
-	code := run(args, stdout, new(bytes.Buffer))
+	code := run(args, stdout, new(bytes.Buffer), h)
 	if code != 0 {
 		t.Errorf("expected exit code 0 on cancel, got %d", code)
 	}
@@ -403,3 +430,1275 @@ func TestRun_DownloadCancel(t *testing.T) {
 		t.Errorf("expected cancelled message, got %s", stdout.String())
 	}
 }
+
+func TestRun_ConfigFail(t *testing.T) {
+	h := testHooks()
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--config", "/nonexistent/config.json"}
+
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Error loading config") {
+		t.Errorf("expected error message, got %s", stdout.String())
+	}
+}
+
+func TestRun_WritePages(t *testing.T) {
+	h := testHooks()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html>player</html>"))
+	}))
+	defer ts.Close()
+
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	tmpDir := t.TempDir()
+	args := []string{"cfs-dl", "--url", ts.URL + "/iframe", "--output-dir", tmpDir, "--write-pages"}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "output.page.html"))
+	if err != nil {
+		t.Fatalf("expected page to be saved: %v", err)
+	}
+	if string(data) != "<html>player</html>" {
+		t.Errorf("unexpected page content %q", string(data))
+	}
+}
+
+func TestRun_WritePagesTimeout(t *testing.T) {
+	defer func(p httpclient.RetryPolicy) { pageRetry = p }(pageRetry)
+	pageRetry = httpclient.RetryPolicy{Attempts: 1, Timeout: 50 * time.Millisecond}
+
+	stop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer ts.Close()
+	defer close(stop)
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", ts.URL + "/iframe", "--output-dir", t.TempDir(), "--write-pages"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "failed to save page") {
+		t.Errorf("expected a warning for the page that never answered, got %s", stdout.String())
+	}
+}
+
+func TestRun_SyncDrift(t *testing.T) {
+	h := testHooks()
+
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+	h.LookPath = func(file string) (string, error) {
+		return "/usr/bin/" + file, nil
+	}
+	h.CheckSync = func(file string) (float64, error) {
+		return 2.0, nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "drift by 2.000s") {
+		t.Errorf("expected drift warning, got %s", stdout.String())
+	}
+}
+
+func TestRun_MaxDuration(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		mpd := simpleMPD("a")
+		mpd.MediaPresentationDuration = "PT2H30M0S"
+		return mpd, nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		t.Error("download should not start for a skipped video")
+		return "", fmt.Errorf("unexpected download")
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--max-duration", "2h"}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Skipping: video is 2h30m0s long") {
+		t.Errorf("expected skip message, got %s", stdout.String())
+	}
+}
+
+func TestRun_ProgressSocketFail(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{}, nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--progress-socket", "/nonexistent/dir/cfs-dl.sock"}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Error opening progress socket") {
+		t.Errorf("expected error message, got %s", stdout.String())
+	}
+}
+
+func TestRun_ProgressWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var got []progress.WebhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p progress.WebhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		opts.Progress(downloader.Progress{RepresentationID: rep.ID, Done: 2, Total: 2, Bytes: 100})
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--progress-webhook", srv.URL, "--job-id", "job-1"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 {
+		t.Fatalf("expected 2 progress updates and a done event, got %+v", got)
+	}
+	if got[0].JobID != "job-1" || got[0].Stream != "video" || got[0].Percent != 100 {
+		t.Errorf("unexpected first update %+v", got[0])
+	}
+	if got[2].Type != "done" {
+		t.Errorf("expected a done event last, got %+v", got[2])
+	}
+}
+
+func TestRun_ProgressWebhookFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	h := testHooks()
+	tmpl := model.SegmentTemplate{StartNumber: 1, Duration: 4, Timescale: 1}
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return segmentedMPD("audio", "PT8S", tmpl), nil
+	}
+	// Complete streams, so only the webhook can fail a --strict run
+	dir := t.TempDir()
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		path := filepath.Join(dir, rep.ID+".mp4")
+		return path, os.WriteFile(path, testStream(3), 0644)
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+	h.CheckSync = func(file string) (float64, error) { return 0, nil }
+	h.LookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+			args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--progress-webhook", srv.URL}
+			want := 0
+			if strict {
+				args, want = append(args, "--strict"), 1
+			}
+			if code := run(args, stdout, new(bytes.Buffer), h); code != want {
+				t.Errorf("expected exit code %d, got %d: %s", want, code, stdout.String())
+			}
+			if !strings.Contains(stdout.String(), "progress webhook failed") {
+				t.Errorf("expected the webhook failure to be reported, got %s", stdout.String())
+			}
+		})
+	}
+}
+
+func TestRun_InvalidProxy(t *testing.T) {
+	h := testHooks()
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--proxy", "ftp://proxy:21"}
+
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "unsupported proxy scheme") {
+		t.Errorf("expected error message, got %s", stdout.String())
+	}
+}
+
+func TestRun_PanicRecovered(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		panic("mock panic")
+	}
+
+	stdout := new(bytes.Buffer)
+	tmpDir := t.TempDir()
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", tmpDir}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != exitCodePanic {
+		t.Errorf("expected exit code %d, got %d", exitCodePanic, code)
+	}
+	if !strings.Contains(stdout.String(), "Crash report written to") {
+		t.Errorf("expected crash report message, got %s", stdout.String())
+	}
+}
+
+func TestRun_WorkerPanic(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "", fmt.Errorf("wrapped: %w", &downloader.PanicError{Segment: 42, Value: "boom", Stack: []byte("stack")})
+	}
+
+	stdout := new(bytes.Buffer)
+	tmpDir := t.TempDir()
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", tmpDir}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != exitCodePanic {
+		t.Errorf("expected exit code %d, got %d", exitCodePanic, code)
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(tmpDir, "cfs-dl-crash-*.txt"))
+	if len(reports) != 1 {
+		t.Fatalf("expected one crash report in %s, got %v", tmpDir, reports)
+	}
+	data, _ := os.ReadFile(reports[0])
+	for _, want := range []string{"Phase: video", "Representation: 1080p", "Segment: 42", "Panic: boom"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in crash report, got %s", want, data)
+		}
+	}
+}
+
+func TestRun_InitPanic(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("a"), nil
+	}
+	h.PrefetchInit = func(ctx context.Context, baseUrl string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error) {
+		return nil, fmt.Errorf("init segment for 1080p: %w", &downloader.PanicError{Segment: -1, Value: "boom", Stack: []byte("stack")})
+	}
+
+	stdout := new(bytes.Buffer)
+	tmpDir := t.TempDir()
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", tmpDir}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != exitCodePanic {
+		t.Errorf("expected exit code %d, got %d", exitCodePanic, code)
+	}
+	reports, _ := filepath.Glob(filepath.Join(tmpDir, "cfs-dl-crash-*.txt"))
+	if len(reports) != 1 {
+		t.Fatalf("expected one crash report in %s, got %v", tmpDir, reports)
+	}
+	data, _ := os.ReadFile(reports[0])
+	if !strings.Contains(string(data), "Phase: init") || strings.Contains(string(data), "Segment:") {
+		t.Errorf("expected an init phase crash report without a segment, got %s", data)
+	}
+}
+
+func TestRun_DumpJSON(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return testFormatsMPD(), nil
+	}
+	// Probing must work without ffmpeg
+	h.LookPath = func(file string) (string, error) {
+		return "", fmt.Errorf("not found")
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--dump-json"}
+	code := run(args, stdout, new(bytes.Buffer), h)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	var info videoInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Errorf("expected only JSON on stdout, got %q", stdout.String())
+	}
+}
+
+func TestRun_SelectionFlags(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{
+			Period: model.Period{
+				AdaptationSets: []model.AdaptationSet{
+					{MimeType: "video/mp4", Representations: []model.Representation{
+						{ID: "avc", Height: 1080, Bandwidth: 4000000, Codecs: "avc1.640028"},
+						{ID: "hevc", Height: 1080, Bandwidth: 2500000, Codecs: "hvc1.1.6.L120"},
+					}},
+					{MimeType: "audio/mp4", Lang: "en", Representations: []model.Representation{{ID: "en", Bandwidth: 128000}}},
+					{MimeType: "audio/mp4", Lang: "de", Representations: []model.Representation{{ID: "de", Bandwidth: 96000}}},
+				},
+			},
+		}, nil
+	}
+	var got []string
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		got = append(got, rep.ID)
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--vcodec", "hvc1", "--audio-lang", "de"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if strings.Join(got, ",") != "hevc,de" {
+		t.Errorf("expected hevc video and de audio, got %v", got)
+	}
+}
+
+func TestRun_AudioCompatibility(t *testing.T) {
+	manifest := func(audio ...model.Representation) func(ctx context.Context, url string) (*model.MPD, error) {
+		return func(ctx context.Context, url string) (*model.MPD, error) {
+			return &model.MPD{
+				Period: model.Period{
+					AdaptationSets: []model.AdaptationSet{
+						{MimeType: "video/mp4", Representations: []model.Representation{{ID: "1080p", Height: 1080, Codecs: "avc1.640028"}}},
+						{MimeType: "audio/mp4", Representations: audio},
+					},
+				},
+			}, nil
+		}
+	}
+	opus := model.Representation{ID: "opus", Bandwidth: 160000, Codecs: "opus"}
+	aac := model.Representation{ID: "aac", Bandwidth: 128000, Codecs: "mp4a.40.2"}
+
+	setup := func() (Hooks, *[]string, *string) {
+		h := testHooks()
+		var got []string
+		var merged string
+		h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+			got = append(got, rep.ID)
+			return rep.ID + ".mp4", nil
+		}
+		h.TranscodeAudio = func(ctx context.Context, audioFile string) (string, error) {
+			got = append(got, "transcode "+audioFile)
+			return "converted.m4a", nil
+		}
+		h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+			merged = a
+			return nil
+		}
+		return h, &got, &merged
+	}
+
+	t.Run("Prefers AAC", func(t *testing.T) {
+		h, got, merged := setup()
+		h.ParseManifest = manifest(opus, aac)
+		args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+		if code := run(args, new(bytes.Buffer), new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if strings.Join(*got, ",") != "1080p,aac" || *merged != "aac.mp4" {
+			t.Errorf("expected the AAC track merged as is, got %v and %s", *got, *merged)
+		}
+	})
+
+	t.Run("Converts Opus", func(t *testing.T) {
+		h, got, merged := setup()
+		h.ParseManifest = manifest(opus)
+		stdout := new(bytes.Buffer)
+		args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		if strings.Join(*got, ",") != "1080p,opus,transcode opus.mp4" || *merged != "converted.m4a" {
+			t.Errorf("expected the Opus track converted before merging, got %v and %s", *got, *merged)
+		}
+		if !strings.Contains(stdout.String(), "converting it to AAC") {
+			t.Errorf("expected a note about the conversion, got %s", stdout.String())
+		}
+	})
+
+	t.Run("Explicit codec", func(t *testing.T) {
+		h, got, merged := setup()
+		h.ParseManifest = manifest(opus, aac)
+		args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--acodec", "opus"}
+		if code := run(args, new(bytes.Buffer), new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if strings.Join(*got, ",") != "1080p,opus" || *merged != "opus.mp4" {
+			t.Errorf("expected the requested codec kept as is, got %v and %s", *got, *merged)
+		}
+	})
+
+	t.Run("Conversion fails", func(t *testing.T) {
+		h, _, _ := setup()
+		h.ParseManifest = manifest(opus)
+		h.TranscodeAudio = func(ctx context.Context, audioFile string) (string, error) {
+			return "", fmt.Errorf("ffmpeg audio conversion failed")
+		}
+		stdout := new(bytes.Buffer)
+		args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+			t.Fatalf("expected exit code 1, got %d", code)
+		}
+		if !strings.Contains(stdout.String(), "Error converting audio") {
+			t.Errorf("expected conversion error, got %s", stdout.String())
+		}
+	})
+}
+
+func TestRun_HDR(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{
+			Period: model.Period{
+				AdaptationSets: []model.AdaptationSet{
+					{MimeType: "video/mp4", Representations: []model.Representation{{ID: "1080p", Height: 1080, Codecs: "vp09.02.40.10.01.09.16.09.00"}}},
+					{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "audio", Codecs: "mp4a.40.2"}}},
+				},
+			},
+		}, nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return rep.ID + ".mp4", nil
+	}
+	var tonemapped, merged string
+	h.TonemapSDR = func(ctx context.Context, videoFile string) (string, error) {
+		tonemapped = videoFile
+		return "1080p.sdr.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		merged = v
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "Warning: video is PQ HDR") || tonemapped != "" || merged != "1080p.mp4" {
+		t.Errorf("expected only a warning without --tonemap, got %q merged from %s:\n%s", tonemapped, merged, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run(append(args, "--tonemap", "sdr"), stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if tonemapped != "1080p.mp4" || merged != "1080p.sdr.mp4" {
+		t.Errorf("expected the SDR video to be merged, got %q merged from %s", tonemapped, merged)
+	}
+	if strings.Contains(stdout.String(), "HDR and will look washed out") {
+		t.Errorf("expected no HDR warning with --tonemap sdr, got %s", stdout.String())
+	}
+
+	if code := run(append(args, "--tonemap", "hdr10"), new(bytes.Buffer), new(bytes.Buffer), h); code != 1 {
+		t.Errorf("expected exit code 1 for an unknown --tonemap mode, got %d", code)
+	}
+}
+
+func TestRun_PrefetchInitFail(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	h.PrefetchInit = func(ctx context.Context, base string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error) {
+		return nil, fmt.Errorf("init segment for audio: status 403 Forbidden")
+	}
+	downloaded := false
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		downloaded = true
+		return "", nil
+	}
+
+	stdout := new(bytes.Buffer)
+	code := run([]string{"cfs-dl", "--url", "https://example.com/iframe"}, stdout, new(bytes.Buffer), h)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if downloaded {
+		t.Error("media download started despite failed init prefetch")
+	}
+	if !strings.Contains(stdout.String(), "Error fetching init segments") {
+		t.Errorf("expected init error message, got %s", stdout.String())
+	}
+}
+
+func TestRun_MergeQuery(t *testing.T) {
+	h := testHooks()
+
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	var modes []downloader.QueryMode
+	h.PrefetchInit = func(ctx context.Context, base string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error) {
+		modes = append(modes, mode)
+		return make([][]byte, len(reps)), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		modes = append(modes, opts.QueryMode)
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--merge-query"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if len(modes) != 3 {
+		t.Fatalf("expected prefetch and two downloads, got %v", modes)
+	}
+	for _, m := range modes {
+		if m != downloader.QueryMerge {
+			t.Errorf("expected QueryMerge everywhere, got %v", modes)
+			break
+		}
+	}
+}
+
+func TestRun_LowMemory(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	var lowMemory []bool
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		lowMemory = append(lowMemory, opts.LowMemory)
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--low-memory"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if len(lowMemory) != 2 || !lowMemory[0] || !lowMemory[1] {
+		t.Errorf("expected both streams in low-memory mode, got %v", lowMemory)
+	}
+}
+
+func TestRun_DumpSegments(t *testing.T) {
+	tmpl := model.SegmentTemplate{Initialization: "$RepresentationID$/init.mp4", Media: "$RepresentationID$/$Number$.m4s", StartNumber: 1, Duration: 4, Timescale: 1}
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return segmentedMPD("audio", "PT4S", tmpl), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		t.Error("--dump-segments must not download")
+		return "", nil
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		args := []string{"cfs-dl", "--url", "https://example.com/abc/iframe", "--dump-segments", "text"}
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		lines := strings.Fields(stdout.String())
+		if len(lines) != 6 {
+			t.Fatalf("expected init and 2 media URLs per stream, got %q", stdout.String())
+		}
+		if !strings.HasSuffix(lines[0], "/1080p/init.mp4") || !strings.HasSuffix(lines[3], "/audio/init.mp4") {
+			t.Errorf("expected video then audio, each starting with its init segment, got %q", lines)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		args := []string{"cfs-dl", "--url", "https://example.com/abc/iframe", "--dump-segments", "json"}
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		var lists []segmentList
+		if err := json.Unmarshal(stdout.Bytes(), &lists); err != nil {
+			t.Fatalf("stdout is not JSON: %v\n%s", err, stdout.String())
+		}
+		if len(lists) != 2 || lists[0].Kind != "video" || lists[1].RepresentationID != "audio" || len(lists[1].Media) != 2 {
+			t.Errorf("unexpected segment lists %+v", lists)
+		}
+	})
+
+	t.Run("Bad format", func(t *testing.T) {
+		args := []string{"cfs-dl", "--url", "https://example.com/abc/iframe", "--dump-segments", "csv"}
+		if code := run(args, new(bytes.Buffer), new(bytes.Buffer), h); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	})
+}
+
+func TestRun_ConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.json")
+	outDir := filepath.Join(dir, "videos")
+	cfgData := fmt.Sprintf(`{"output_dir": %q, "resolution": "360p", "workers": 2}`, outDir)
+	if err := os.WriteFile(cfgPath, []byte(cfgData), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{
+			Period: model.Period{
+				AdaptationSets: []model.AdaptationSet{
+					{MimeType: "video/mp4", Representations: []model.Representation{{ID: "360p", Height: 360}, {ID: "720p", Height: 720}}},
+					{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "a"}}},
+				},
+			},
+		}, nil
+	}
+	var gotRep string
+	var gotWorkers []int
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		if rep.Height > 0 {
+			gotRep = rep.ID
+		}
+		gotWorkers = append(gotWorkers, opts.Workers)
+		return "temp.mp4", nil
+	}
+	var gotOutput string
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		gotOutput = o
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--config", cfgPath, "--workers", "7"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if gotRep != "360p" {
+		t.Errorf("expected resolution from config, got %q", gotRep)
+	}
+	if filepath.Dir(gotOutput) != outDir {
+		t.Errorf("expected output in %s from config, got %q", outDir, gotOutput)
+	}
+	if len(gotWorkers) != 2 || gotWorkers[0] != 7 {
+		t.Errorf("expected --workers to win over the config, got %v", gotWorkers)
+	}
+}
+
+func TestRunner_Config(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{
+			Period: model.Period{
+				AdaptationSets: []model.AdaptationSet{
+					{MimeType: "video/mp4", Representations: []model.Representation{{ID: "360p", Height: 360}, {ID: "720p", Height: 720}}},
+					{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "a", Bandwidth: 100}}},
+				},
+			},
+			ProgramInformation: &model.ProgramInformation{Title: "Ignored"},
+		}, nil
+	}
+	var gotRep string
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		if rep.Height > 0 {
+			gotRep = rep.ID
+		}
+		return "temp.mp4", nil
+	}
+	var gotOutput string
+	var gotMeta map[string]string
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		gotOutput, gotMeta = o, meta
+		return nil
+	}
+
+	dir := t.TempDir()
+	cfg := Config{
+		URL:       "https://example.com/iframe",
+		OutputDir: dir,
+		Filename:  "clip.mp4",
+		Video:     model.SelectionPolicy{Height: 360},
+		Hooks:     h,
+	}
+	stdout := new(bytes.Buffer)
+	if code := NewRunner(cfg, stdout, new(bytes.Buffer)).Run(context.Background()); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if gotRep != "360p" {
+		t.Errorf("expected 360p from the video policy, got %q", gotRep)
+	}
+	if gotOutput != dir+"/clip.mp4" {
+		t.Errorf("expected explicit filename to win over the title, got %q", gotOutput)
+	}
+	if gotMeta != nil {
+		t.Errorf("expected no metadata without EmbedSource, got %v", gotMeta)
+	}
+}
+
+func TestRunner_CancelledDuringManifest(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stdout := new(bytes.Buffer)
+	cfg := Config{URL: "https://example.com/iframe", OutputDir: t.TempDir(), Hooks: h}
+	if code := NewRunner(cfg, stdout, new(bytes.Buffer)).Run(ctx); code != 0 {
+		t.Errorf("expected exit code 0 on cancel, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Download cancelled") {
+		t.Errorf("expected cancelled message, got %s", stdout.String())
+	}
+}
+
+func TestRunner_CancelReason(t *testing.T) {
+	mpd := simpleMPD("audio")
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return mpd, nil
+	}
+	// The download runs until the run's context ends, like a slow stream
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	tests := []struct {
+		name    string
+		cause   error
+		timeout time.Duration
+		code    int
+		message string
+	}{
+		{"Timeout", nil, 10 * time.Millisecond, 124, "Download cancelled: --timeout reached."},
+		{"SIGINT", errInterrupted, 0, 130, "Download cancelled: interrupted (SIGINT)."},
+		{"SIGTERM", errTerminated, 0, 143, "Download cancelled: terminated (SIGTERM)."},
+		{"No reason", context.Canceled, 0, 0, "Download cancelled: cancelled."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			if tt.cause != nil {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					cancel(tt.cause)
+				}()
+			}
+
+			stdout := new(bytes.Buffer)
+			cfg := Config{URL: "https://example.com/iframe", OutputDir: t.TempDir(), Timeout: tt.timeout, Hooks: h}
+			if code := NewRunner(cfg, stdout, new(bytes.Buffer)).Run(ctx); code != tt.code {
+				t.Errorf("expected exit code %d, got %d", tt.code, code)
+			}
+			if !strings.Contains(stdout.String(), tt.message) {
+				t.Errorf("expected %q, got %s", tt.message, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunner_MergeCancelled(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return rep.ID + ".mp4", nil
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// The merge runs until it is interrupted, like a long ffmpeg run
+	h.MergeAudioVideo = func(mctx context.Context, v, a, o string, meta map[string]string) error {
+		cancel(errInterrupted)
+		<-mctx.Done()
+		return fmt.Errorf("ffmpeg merge cancelled: %w", mctx.Err())
+	}
+
+	stdout := new(bytes.Buffer)
+	cfg := Config{URL: "https://example.com/iframe", OutputDir: t.TempDir(), Hooks: h}
+	if code := NewRunner(cfg, stdout, new(bytes.Buffer)).Run(ctx); code != 130 {
+		t.Errorf("expected exit code 130, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Merge cancelled: interrupted (SIGINT).") || strings.Contains(stdout.String(), "Error combining") {
+		t.Errorf("expected the merge to be reported as cancelled, got %s", stdout.String())
+	}
+}
+
+func TestRun_RemuxFallback(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return rep.ID + ".mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return fmt.Errorf("ffmpeg merge failed: exit status 1\nNon-monotonic DTS")
+	}
+	remuxed := 0
+	h.RemuxAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		remuxed++
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if remuxed != 1 || !strings.Contains(stdout.String(), "retrying with regenerated timestamps") {
+		t.Errorf("expected one remux after the failed merge, got %d:\n%s", remuxed, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run(append(args, "--no-remux-fallback"), stdout, new(bytes.Buffer), h); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if remuxed != 1 || !strings.Contains(stdout.String(), "Error combining video and audio") {
+		t.Errorf("expected the merge error without a remux, got %d:\n%s", remuxed, stdout.String())
+	}
+}
+
+func TestRun_AllFormats(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return &model.MPD{
+			Period: model.Period{
+				AdaptationSets: []model.AdaptationSet{
+					{MimeType: "video/mp4", Representations: []model.Representation{{ID: "360p", Height: 360}, {ID: "1080p", Height: 1080}}},
+					{MimeType: "audio/mp4", Representations: []model.Representation{{ID: "aac", Bandwidth: 128000}}},
+					{MimeType: "text/vtt", Representations: []model.Representation{{ID: "subs"}}},
+				},
+			},
+		}, nil
+	}
+	var prefetched int
+	h.PrefetchInit = func(ctx context.Context, base string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error) {
+		prefetched = len(reps)
+		return make([][]byte, len(reps)), nil
+	}
+	src := t.TempDir()
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		path := filepath.Join(src, rep.ID+".tmp")
+		return path, os.WriteFile(path, []byte(rep.ID), 0644)
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		t.Error("nothing should be merged with --all-formats")
+		return nil
+	}
+	// No ffmpeg needed when nothing is merged
+	h.LookPath = func(file string) (string, error) {
+		return "", fmt.Errorf("not found")
+	}
+
+	dir := t.TempDir()
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", dir, "--all-formats"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if prefetched != 3 {
+		t.Errorf("expected 3 init segments prefetched, got %d", prefetched)
+	}
+	for _, name := range []string{"output.video-360p.mp4", "output.video-1080p.mp4", "output.audio-aac.mp4"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(src, "360p.tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected stream file to be moved, stat err = %v", err)
+	}
+}
+
+func mp4Box(boxType string, payload string) []byte {
+	size := 8 + len(payload)
+	return append([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), boxType[0], boxType[1], boxType[2], boxType[3]}, payload...)
+}
+
+// testStream builds a stream file with an init segment and the given number of fragments.
+func testStream(fragments int) []byte {
+	data := append(mp4Box("ftyp", "iso6"), mp4Box("moov", "")...)
+	for i := 0; i < fragments; i++ {
+		data = append(data, mp4Box("moof", "")...)
+		data = append(data, mp4Box("mdat", "media")...)
+	}
+	return data
+}
+
+func TestRun_RefetchMissing(t *testing.T) {
+	for _, refetch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("refetch=%v", refetch), func(t *testing.T) {
+			h := testHooks()
+			tmpl := model.SegmentTemplate{StartNumber: 1, Duration: 4, Timescale: 1}
+			h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+				// 8s at 4s per segment is 3 segments, counting the rounding extra
+				return segmentedMPD("a", "PT8S", tmpl), nil
+			}
+			dir := t.TempDir()
+			calls := map[string]int{}
+			h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+				calls[rep.ID]++
+				fragments := 3
+				if rep.ID == "1080p" && calls[rep.ID] == 1 {
+					fragments = 1 // First pass comes up short
+				}
+				path := filepath.Join(dir, rep.ID+".mp4")
+				return path, os.WriteFile(path, testStream(fragments), 0644)
+			}
+			merged := false
+			h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+				merged = true
+				return nil
+			}
+
+			stdout := new(bytes.Buffer)
+			args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir()}
+			if refetch {
+				args = append(args, "--refetch-missing")
+			}
+			code := run(args, stdout, new(bytes.Buffer), h)
+			if !strings.Contains(stdout.String(), "video stream has 1 of 3 fragments") {
+				t.Errorf("expected incomplete warning, got %s", stdout.String())
+			}
+			if !refetch {
+				if code != 1 || merged {
+					t.Errorf("expected failure before merge, got code %d, merged %v", code, merged)
+				}
+				return
+			}
+			if code != 0 || !merged {
+				t.Fatalf("expected merge after re-fetch, got code %d: %s", code, stdout.String())
+			}
+			if calls["1080p"] != 2 || calls["a"] != 1 {
+				t.Errorf("expected only the video to be re-fetched, got %v", calls)
+			}
+		})
+	}
+}
+
+func TestRun_RefetchCheckFails(t *testing.T) {
+	h := testHooks()
+	tmpl := model.SegmentTemplate{StartNumber: 1, Duration: 4, Timescale: 1}
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return segmentedMPD("a", "PT8S", tmpl), nil
+	}
+	dir := t.TempDir()
+	calls := 0
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		path := filepath.Join(dir, rep.ID+".mp4")
+		if rep.ID == "1080p" {
+			if calls++; calls == 2 {
+				// The re-fetched file can't be read back
+				return filepath.Join(dir, "missing.mp4"), nil
+			}
+			return path, os.WriteFile(path, testStream(1), 0644)
+		}
+		return path, os.WriteFile(path, testStream(3), 0644)
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--refetch-missing"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Error checking video stream after re-fetching:") || strings.Contains(stdout.String(), "0 of 0 fragments") {
+		t.Errorf("expected the check error to be reported, got %s", stdout.String())
+	}
+}
+
+func TestRun_LoadInfo(t *testing.T) {
+	mpd := testFormatsMPD()
+	for i := range mpd.Period.AdaptationSets {
+		for j := range mpd.Period.AdaptationSets[i].Representations {
+			mpd.Period.AdaptationSets[i].Representations[j].SegmentTemplate = model.SegmentTemplate{Media: "seg-$Number$.m4s", Duration: 4, Timescale: 1}
+		}
+	}
+	infoPath := filepath.Join(t.TempDir(), "video.info.json")
+	out := new(bytes.Buffer)
+	if err := dumpJSON(out, newVideoInfo("https://example.com/abc/iframe", "https://cdn.example.com/abc/video.mpd?token=1", mpd)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(infoPath, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		t.Error("manifest should not be fetched with --load-info")
+		return nil, fmt.Errorf("unexpected fetch")
+	}
+	var bases, reps []string
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		bases = append(bases, base)
+		reps = append(reps, rep.ID)
+		if dur != 60 || rep.SegmentTemplate.Media != "seg-$Number$.m4s" {
+			t.Errorf("unexpected duration %v or template %+v", dur, rep.SegmentTemplate)
+		}
+		return "temp.mp4", nil
+	}
+	var output string
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		output = o
+		return nil
+	}
+
+	dir := t.TempDir()
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "download", "--load-info", infoPath, "--output-dir", dir}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if strings.Join(reps, ",") != "v1,a1" {
+		t.Errorf("expected v1 and a1, got %v", reps)
+	}
+	if len(bases) == 0 || bases[0] != "https://cdn.example.com/abc/video.mpd?token=1" {
+		t.Errorf("expected segments resolved against the saved manifest URL, got %v", bases)
+	}
+	if output != dir+"/Demo.mp4" {
+		t.Errorf("expected title from the info file, got %q", output)
+	}
+}
+
+func TestRun_LoadInfoFail(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "download", "--load-info", "/nonexistent/video.info.json"}
+	if code := run(args, stdout, new(bytes.Buffer), testHooks()); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Error loading info") {
+		t.Errorf("expected error message, got %s", stdout.String())
+	}
+}
+
+func TestRun_Exec(t *testing.T) {
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		mpd := simpleMPD("a")
+		mpd.MediaPresentationDuration = "PT30S"
+		mpd.ProgramInformation = &model.ProgramInformation{Title: "Clip"}
+		return mpd, nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		return "temp.mp4", nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return nil
+	}
+
+	dir := t.TempDir()
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", dir, "--exec", `echo "hook $CFS_TITLE $CFS_DURATION $CFS_PATH"`}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if want := "hook Clip 30 " + dir + "/Clip.mp4"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q from the hook, got %s", want, stdout.String())
+	}
+
+	t.Run("Run timeout", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", t.TempDir(), "--exec", "sleep 10", "--timeout", "200ms"}
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 124 {
+			t.Errorf("expected exit code 124, got %d: %s", code, stdout.String())
+		}
+		if !strings.Contains(stdout.String(), "--exec command cancelled: --timeout reached.") {
+			t.Errorf("expected the run timeout to be reported, got %s", stdout.String())
+		}
+	})
+}
+
+func TestRun_Cookie(t *testing.T) {
+	var gotCookie string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCookie = r.Header.Get("Cookie")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		resp, err := httpclient.Shared().Get(url)
+		if err != nil {
+			return nil, err
+		}
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", ts.URL + "/abc/iframe", "--cookie", "CloudFront-Policy=p", "--cookie", "CloudFront-Signature=s"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if gotCookie != "CloudFront-Policy=p; CloudFront-Signature=s" {
+		t.Errorf("expected signed cookies on manifest request, got %q", gotCookie)
+	}
+}
+
+func TestRun_SlowSegment(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/2.m4s") {
+			time.Sleep(60 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("segment"))
+	}))
+	defer ts.Close()
+
+	h := testHooks()
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		return simpleMPD("audio"), nil
+	}
+	dir := t.TempDir()
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		for n := 1; n <= 3; n++ {
+			if _, err := httpclient.Fetch(ctx, fmt.Sprintf("%s/%s/%d.m4s", ts.URL, rep.ID, n), httpclient.DefaultRetry); err != nil {
+				return "", err
+			}
+		}
+		return filepath.Join(dir, rep.ID+".mp4"), nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return os.WriteFile(o, []byte("merged"), 0644)
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", dir, "--slow-segment", "40ms", "--write-stats"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "): "+ts.URL+"/1080p/2.m4s") || !strings.Contains(stdout.String(), "Segment latency for audio: 3 requests") {
+		t.Errorf("expected the slow segments to be logged, got %s", stdout.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "output.stats.json"))
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+	var stats downloadStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("invalid stats JSON: %v", err)
+	}
+	for _, st := range stats.Streams {
+		if st.Latency.Requests != 3 || len(st.Latency.Slow) != 1 || st.Latency.Slow[0].URL != ts.URL+"/"+st.RepresentationID+"/2.m4s" {
+			t.Errorf("unexpected %s latency %+v", st.Kind, st.Latency)
+		}
+	}
+}
+
+func TestRun_Strict(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		ffprobe  bool
+		drift    float64
+		wantCode int
+		wantOut  string
+		wantFile bool
+	}{
+		{"Clean", []string{"--strict"}, true, 0.1, 0, "Successfully created", true},
+		{"Fallback resolution", []string{"--strict", "--resolution", "720p"}, true, 0.1, 1, "720p is not available, falling back to 1080p (--strict)", false},
+		{"Drift", []string{"--strict"}, true, 2, 1, "audio and video drift by 2.000s", false},
+		{"Drift without strict", nil, true, 2, 0, "Warning: audio and video drift", true},
+		{"No ffprobe", []string{"--strict"}, false, 0, 1, "ffprobe not found", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHooks()
+			tmpl := model.SegmentTemplate{StartNumber: 1, Duration: 4, Timescale: 1}
+			h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+				return segmentedMPD("a", "PT8S", tmpl), nil
+			}
+			dir := t.TempDir()
+			h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+				path := filepath.Join(dir, rep.ID+".mp4")
+				return path, os.WriteFile(path, testStream(3), 0644)
+			}
+			h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+				return os.WriteFile(o, []byte("merged"), 0644)
+			}
+			h.LookPath = func(file string) (string, error) {
+				if file == "ffprobe" && !tt.ffprobe {
+					return "", fmt.Errorf("not found")
+				}
+				return "/usr/bin/" + file, nil
+			}
+			h.CheckSync = func(file string) (float64, error) {
+				return tt.drift, nil
+			}
+
+			outDir := t.TempDir()
+			stdout := new(bytes.Buffer)
+			args := append([]string{"cfs-dl", "--url", "https://example.com/iframe", "--output-dir", outDir}, tt.args...)
+			if code := run(args, stdout, new(bytes.Buffer), h); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d: %s", tt.wantCode, code, stdout.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) {
+				t.Errorf("expected output to contain %q, got %s", tt.wantOut, stdout.String())
+			}
+			_, err := os.Stat(filepath.Join(outDir, "output.mp4"))
+			if exists := err == nil; exists != tt.wantFile {
+				t.Errorf("expected output file to exist: %v, got %v", tt.wantFile, exists)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cfs-dl/internal/config"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runPrune implements "cfs-dl prune", which applies a retention policy to
+// past downloads, e.g. from cron on a kiosk that caches recent videos. Only
+// downloads recorded by --write-stats are touched, so other files in the
+// output directory are safe.
+func runPrune(prog string, args []string, stdout, stderr io.Writer, hooks Hooks) int {
+	fs := flag.NewFlagSet(prog+" prune", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	olderThanPtr := fs.String("older-than", "", "Remove downloads finished longer ago than this, e.g. 30d or 12h")
+	maxSizePtr := fs.String("max-size", "", "Remove the oldest downloads until the rest fit in this size, e.g. 50GB or 200GiB")
+	moveToPtr := fs.String("move-to", "", "Move removed downloads to this directory instead of deleting them")
+	dryRunPtr := fs.Bool("dry-run", false, "Only report what would be removed")
+	configPtr := fs.String("config", "", "Path to a JSON config file whose output_dir is pruned (default: "+config.DefaultPath()+")")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: %s prune [--older-than <age>] [--max-size <size>] [options] [dir...]\n", prog)
+		_, _ = fmt.Fprintf(stderr, "\nDeletes or moves downloads made with --write-stats that are too old or don't fit the size budget.\n")
+		_, _ = fmt.Fprintf(stderr, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	var olderThan time.Duration
+	var maxSize int64 = -1
+	var err error
+	if *olderThanPtr != "" {
+		if olderThan, err = parseAge(*olderThanPtr); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error: invalid --older-than: %v\n", err)
+			return 1
+		}
+	}
+	if *maxSizePtr != "" {
+		if maxSize, err = parseSize(*maxSizePtr); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error: invalid --max-size: %v\n", err)
+			return 1
+		}
+	}
+	if olderThan <= 0 && maxSize < 0 {
+		_, _ = fmt.Fprintln(stdout, "Error: --older-than or --max-size is required")
+		fs.Usage()
+		return 1
+	}
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		cfgPath, cfgRequired := *configPtr, true
+		if cfgPath == "" {
+			cfgPath, cfgRequired = config.DefaultPath(), false
+		}
+		fileCfg, err := config.Load(cfgPath, cfgRequired)
+		if err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error loading config: %v\n", err)
+			return 1
+		}
+		dirs = []string{historyRoot(fileCfg.OutputDir)}
+	}
+	records, err := loadHistory(dirs, stderr)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error reading history: %v\n", err)
+		return 1
+	}
+
+	verb := "Deleted"
+	switch {
+	case *dryRunPtr && *moveToPtr != "":
+		verb = "Would move"
+	case *dryRunPtr:
+		verb = "Would delete"
+	case *moveToPtr != "":
+		verb = "Moved"
+		if err := os.MkdirAll(*moveToPtr, 0755); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	code := 0
+	var freed int64
+	candidates := pruneCandidates(records, time.Now(), olderThan, maxSize)
+	for _, c := range candidates {
+		if !*dryRunPtr {
+			if err := removeDownload(c.historyRecord, *moveToPtr); err != nil {
+				_, _ = fmt.Fprintf(stdout, "Error removing %s: %v\n", c.Output, err)
+				code = 1
+				continue
+			}
+		}
+		freed += c.Size
+		_, _ = fmt.Fprintf(stdout, "%s %s (%s, %s)\n", verb, c.Output, formatBytes(c.Size), c.reason)
+	}
+	_, _ = fmt.Fprintf(stdout, "%s %d of %d downloads, %s\n", verb, len(candidates), len(records), formatBytes(freed))
+	return code
+}
+
+// pruneCandidate is a download the retention policy removes, and why.
+type pruneCandidate struct {
+	historyRecord
+	reason string
+}
+
+// pruneCandidates picks the downloads to remove from records, which are
+// sorted oldest first: those finished more than olderThan before now, then
+// the oldest of the rest until they total at most maxSize. Zero olderThan
+// and negative maxSize disable the respective rule. Downloads whose output
+// is gone or is a directory (--all-formats) are left alone.
+func pruneCandidates(records []historyRecord, now time.Time, olderThan time.Duration, maxSize int64) []pruneCandidate {
+	var kept []historyRecord
+	var picked []pruneCandidate
+	var total int64
+	for _, r := range records {
+		info, err := os.Stat(r.Output)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		r.Size = info.Size()
+		if olderThan > 0 && now.Sub(r.FinishedAt) > olderThan {
+			picked = append(picked, pruneCandidate{r, "finished " + r.FinishedAt.Local().Format("2006-01-02")})
+			continue
+		}
+		kept = append(kept, r)
+		total += r.Size
+	}
+	for _, r := range kept {
+		if maxSize < 0 || total <= maxSize {
+			break
+		}
+		picked = append(picked, pruneCandidate{r, "over the size budget"})
+		total -= r.Size
+	}
+	return picked
+}
+
+// removeDownload deletes a download's output and sidecars, or moves them to
+// dir if it is set. The stats file goes last so a failure leaves the
+// download listed for the next run. Nothing is moved if a file of the same
+// name is already in dir, e.g. from another output directory.
+func removeDownload(r historyRecord, dir string) error {
+	base := strings.TrimSuffix(r.Output, filepath.Ext(r.Output))
+	var files []string
+	for _, f := range []string{r.Output, base + ".page.html", r.StatsFile} {
+		if _, err := os.Stat(f); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if dir != "" {
+			if _, err := os.Lstat(filepath.Join(dir, filepath.Base(f))); err == nil {
+				return fmt.Errorf("%s already exists", filepath.Join(dir, filepath.Base(f)))
+			}
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		var err error
+		if dir == "" {
+			err = os.Remove(f)
+		} else {
+			err = moveFile(f, filepath.Join(dir, filepath.Base(f)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseAge parses a Go duration, also accepting whole days such as "30d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseSize parses a byte count with an optional decimal (KB, MB, GB, TB) or
+// binary (KiB, MiB, GiB, TiB) unit.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"B", 1},
+	}
+	num, mult := strings.TrimSpace(s), 1.0
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size like 50GB", s)
+	}
+	return int64(n * mult), nil
+}
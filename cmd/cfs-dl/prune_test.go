@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunPrune(t *testing.T) {
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		now := time.Now()
+		writeHistoryStats(t, dir, "old", now.Add(-40*24*time.Hour), true)
+		writeHistoryStats(t, dir, "older", now.Add(-50*24*time.Hour), true)
+		writeHistoryStats(t, dir, "recent", now.Add(-2*24*time.Hour), true)
+		writeHistoryStats(t, dir, "new", now.Add(-time.Hour), true)
+		_ = os.WriteFile(filepath.Join(dir, "old.page.html"), []byte("<html>"), 0644)
+		_ = os.WriteFile(filepath.Join(dir, "unrelated.mp4"), []byte("x"), 0644)
+		return dir
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	t.Run("Age", func(t *testing.T) {
+		dir := setup(t)
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "prune", "--older-than", "30d", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		for _, f := range []string{"old.mp4", "old.stats.json", "old.page.html", "older.mp4"} {
+			if exists(filepath.Join(dir, f)) {
+				t.Errorf("expected %s to be deleted", f)
+			}
+		}
+		for _, f := range []string{"recent.mp4", "new.mp4", "unrelated.mp4"} {
+			if !exists(filepath.Join(dir, f)) {
+				t.Errorf("expected %s to be kept", f)
+			}
+		}
+		if !strings.Contains(stdout.String(), "Deleted 2 of 4 downloads, 9.8 KiB") {
+			t.Errorf("unexpected summary:\n%s", stdout.String())
+		}
+	})
+
+	t.Run("Size budget", func(t *testing.T) {
+		dir := setup(t)
+		stdout := new(bytes.Buffer)
+		// Each output is 5000 bytes, so only the newest fits
+		if code := run([]string{"cfs-dl", "prune", "--max-size", "6KB", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		for _, f := range []string{"older.mp4", "old.mp4", "recent.mp4"} {
+			if exists(filepath.Join(dir, f)) {
+				t.Errorf("expected %s to be deleted", f)
+			}
+		}
+		if !exists(filepath.Join(dir, "new.mp4")) {
+			t.Error("expected the newest download to be kept")
+		}
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		dir := setup(t)
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "prune", "--older-than", "30d", "--dry-run", dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		if !exists(filepath.Join(dir, "old.mp4")) || !exists(filepath.Join(dir, "older.mp4")) {
+			t.Error("expected --dry-run to keep every file")
+		}
+		if !strings.Contains(stdout.String(), "Would delete "+filepath.Join(dir, "older.mp4")) {
+			t.Errorf("expected a report of what would go:\n%s", stdout.String())
+		}
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		dir := setup(t)
+		dest := filepath.Join(t.TempDir(), "cold")
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "prune", "--older-than", "30d", "--move-to", dest, dir}, stdout, new(bytes.Buffer), Hooks{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		if exists(filepath.Join(dir, "old.mp4")) || !exists(filepath.Join(dest, "old.mp4")) || !exists(filepath.Join(dest, "old.stats.json")) {
+			t.Error("expected the old download and its stats to be moved")
+		}
+	})
+
+	t.Run("Move name clash", func(t *testing.T) {
+		dir := setup(t)
+		dest := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dest, "old.mp4"), []byte("other"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stdout := new(bytes.Buffer)
+		if code := run([]string{"cfs-dl", "prune", "--older-than", "30d", "--move-to", dest, dir}, stdout, new(bytes.Buffer), Hooks{}); code != 1 {
+			t.Errorf("expected exit code 1, got %d: %s", code, stdout.String())
+		}
+		if data, _ := os.ReadFile(filepath.Join(dest, "old.mp4")); string(data) != "other" {
+			t.Error("expected the file already in --move-to to be kept")
+		}
+		if !exists(filepath.Join(dir, "old.mp4")) || !exists(filepath.Join(dir, "old.stats.json")) {
+			t.Error("expected the clashing download to stay in place")
+		}
+		if exists(filepath.Join(dir, "older.mp4")) {
+			t.Error("expected the other old download to be moved")
+		}
+	})
+
+	t.Run("No rule", func(t *testing.T) {
+		if code := run([]string{"cfs-dl", "prune", t.TempDir()}, new(bytes.Buffer), new(bytes.Buffer), Hooks{}); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	})
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"50GB", 50e9, true},
+		{"1.5 GiB", 3 << 29, true},
+		{"10 MB", 10e6, true},
+		{"lots", 0, false},
+		{"-1GB", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d (ok=%v)", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	if d, err := parseAge("30d"); err != nil || d != 30*24*time.Hour {
+		t.Errorf("parseAge(30d) = %v, %v", d, err)
+	}
+	if d, err := parseAge("12h"); err != nil || d != 12*time.Hour {
+		t.Errorf("parseAge(12h) = %v, %v", d, err)
+	}
+	if _, err := parseAge("xd"); err == nil {
+		t.Error("expected error for xd")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseRemoteTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want remoteTarget
+	}{
+		{"scp://user@nas/srv/videos/", remoteTarget{Host: "user@nas", Dir: "/srv/videos", raw: "scp://user@nas/srv/videos"}},
+		{"scp://nas:2222/~/videos", remoteTarget{Host: "nas", Port: "2222", Dir: "videos", raw: "scp://nas:2222/~/videos"}},
+		{"scp://nas", remoteTarget{Host: "nas", Dir: ".", raw: "scp://nas"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRemoteTarget(tt.in)
+			if err != nil {
+				t.Fatalf("parseRemoteTarget failed: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, *got)
+			}
+		})
+	}
+
+	if got, err := parseRemoteTarget("data/download"); got != nil || err != nil {
+		t.Errorf("expected nil for a local directory, got %v, %v", got, err)
+	}
+	if _, err := parseRemoteTarget("scp:///videos"); err == nil {
+		t.Error("expected error for a missing host, got nil")
+	}
+	for _, in := range []string{"scp://-oProxyCommand=sh/videos", "scp://-oProxyCommand=sh@nas/videos"} {
+		if _, err := parseRemoteTarget(in); err == nil {
+			t.Errorf("expected error for %s, got nil", in)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("it's here"); got != `'it'\''s here'` {
+		t.Errorf("unexpected quoting %s", got)
+	}
+}
+
+func TestRun_RemoteOutput(t *testing.T) {
+	h := testHooks()
+	h.LookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+		mpd := simpleMPD("audio")
+		mpd.ProgramInformation = &model.ProgramInformation{Title: "Talk"}
+		return mpd, nil
+	}
+	h.DownloadStream = func(ctx context.Context, base string, rep *model.Representation, dur float64, opts downloader.Options) (string, error) {
+		f, err := os.CreateTemp("", "stream-*.mp4")
+		if err != nil {
+			return "", err
+		}
+		_ = f.Close()
+		return f.Name(), nil
+	}
+	h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+		return os.WriteFile(o, []byte("merged"), 0644)
+	}
+	h.CheckSync = func(file string) (float64, error) { return 0, nil }
+
+	var uploaded []string
+	var localDir string
+	h.Upload = func(ctx context.Context, file string, dest *remoteTarget) error {
+		if dest.Host != "me@nas" || dest.Dir != "/srv/abc" {
+			t.Errorf("unexpected target %+v", dest)
+		}
+		localDir = filepath.Dir(file)
+		uploaded = append(uploaded, filepath.Base(file))
+		return nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "--url", "https://example.com/abc/iframe", "--output-dir", "scp://me@nas/srv/{uid}", "--write-stats"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	sort.Strings(uploaded)
+	if strings.Join(uploaded, ",") != "Talk.mp4,Talk.stats.json" {
+		t.Errorf("expected the output and its stats to be uploaded, got %v", uploaded)
+	}
+	if _, err := os.Stat(localDir); !os.IsNotExist(err) {
+		t.Errorf("expected the local copy to be removed after uploading, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Successfully created scp://me@nas/srv/abc/Talk.mp4") {
+		t.Errorf("expected the remote path in the summary, got %s", stdout.String())
+	}
+
+	t.Run("Upload fails", func(t *testing.T) {
+		h.Upload = func(ctx context.Context, file string, dest *remoteTarget) error {
+			localDir = filepath.Dir(file)
+			return errors.New("connection refused")
+		}
+		stdout := new(bytes.Buffer)
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+			t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+		}
+		defer func() { _ = os.RemoveAll(localDir) }()
+		if _, err := os.Stat(filepath.Join(localDir, "Talk.mp4")); err != nil {
+			t.Errorf("expected the output to be kept locally, got %v", err)
+		}
+		if !strings.Contains(stdout.String(), "Output kept in "+localDir) {
+			t.Errorf("expected where the output was kept, got %s", stdout.String())
+		}
+	})
+
+	t.Run("Upload cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+		h.Upload = func(uctx context.Context, file string, dest *remoteTarget) error {
+			localDir = filepath.Dir(file)
+			cancel(errInterrupted)
+			<-uctx.Done()
+			return uctx.Err()
+		}
+		stdout := new(bytes.Buffer)
+		cfg := Config{URL: "https://example.com/abc/iframe", OutputDir: "scp://me@nas/srv/{uid}", Hooks: h}
+		if code := NewRunner(cfg, stdout, new(bytes.Buffer)).Run(ctx); code != 130 {
+			t.Errorf("expected exit code 130, got %d: %s", code, stdout.String())
+		}
+		defer func() { _ = os.RemoveAll(localDir) }()
+		if !strings.Contains(stdout.String(), "Upload cancelled: interrupted (SIGINT).") || strings.Contains(stdout.String(), "Error uploading") {
+			t.Errorf("expected the upload to be reported as cancelled, got %s", stdout.String())
+		}
+	})
+
+	t.Run("Merge fails", func(t *testing.T) {
+		merge, remux := h.MergeAudioVideo, h.RemuxAudioVideo
+		defer func() { h.MergeAudioVideo, h.RemuxAudioVideo = merge, remux }()
+		h.MergeAudioVideo = func(ctx context.Context, v, a, o string, meta map[string]string) error {
+			localDir = filepath.Dir(o)
+			if err := os.WriteFile(o+".ffmpeg.log", []byte("error"), 0644); err != nil {
+				return err
+			}
+			return fmt.Errorf("ffmpeg merge failed: exit status 1 (full log: %s.ffmpeg.log)", o)
+		}
+		h.RemuxAudioVideo = h.MergeAudioVideo
+		stdout := new(bytes.Buffer)
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 1 {
+			t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+		}
+		defer func() { _ = os.RemoveAll(localDir) }()
+		if _, err := os.Stat(filepath.Join(localDir, "Talk.mp4.ffmpeg.log")); err != nil {
+			t.Errorf("expected the ffmpeg log to be kept, got %v", err)
+		}
+		if !strings.Contains(stdout.String(), "Output kept in "+localDir) {
+			t.Errorf("expected where the log was kept, got %s", stdout.String())
+		}
+	})
+
+	t.Run("Title with # and %", func(t *testing.T) {
+		parse := h.ParseManifest
+		h.ParseManifest = func(ctx context.Context, url string) (*model.MPD, error) {
+			mpd, err := parse(ctx, url)
+			mpd.ProgramInformation.Title = "Episode #3: 100% Fun"
+			return mpd, err
+		}
+		var dir string
+		h.Upload = func(ctx context.Context, file string, dest *remoteTarget) error {
+			dir = dest.Dir
+			return nil
+		}
+		args := []string{"cfs-dl", "--url", "https://example.com/abc/iframe", "--output-dir", "scp://me@nas/srv/{title}"}
+		stdout := new(bytes.Buffer)
+		if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+		}
+		if want := "/srv/" + sanitizeFilename("Episode #3: 100% Fun"); dir != want {
+			t.Errorf("expected upload to %q, got %q", want, dir)
+		}
+	})
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/merger"
+	"cfs-dl/internal/model"
+	"cfs-dl/internal/progress"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Hooks are the steps of a run that touch the network or external tools.
+// Nil fields use the real implementations; tests swap in fakes.
+type Hooks struct {
+	ParseManifest   func(ctx context.Context, url string) (*model.MPD, error)
+	PrefetchInit    func(ctx context.Context, baseUrl string, mode downloader.QueryMode, reps ...*model.Representation) ([][]byte, error)
+	DownloadStream  func(ctx context.Context, baseUrl string, rep *model.Representation, totalDurationSecs float64, opts downloader.Options) (string, error)
+	MergeAudioVideo func(ctx context.Context, videoFile, audioFile, outputFile string, metadata map[string]string) error
+	RemuxAudioVideo func(ctx context.Context, videoFile, audioFile, outputFile string, metadata map[string]string) error
+	TranscodeAudio  func(ctx context.Context, audioFile string) (string, error)
+	TonemapSDR      func(ctx context.Context, videoFile string) (string, error)
+	CheckSync       func(file string) (float64, error)
+	LookPath        func(file string) (string, error)
+	Inspect         func(file string) (*merger.FileInfo, error)
+	Upload          func(ctx context.Context, file string, dest *remoteTarget) error
+	SpeedTest       func(ctx context.Context, baseUrl string, rep *model.Representation, mode downloader.QueryMode, segments []int, connections int) (downloader.SpeedResult, error)
+	Stdin           io.Reader // Answers for the setup wizard
+}
+
+func (h Hooks) withDefaults() Hooks {
+	if h.ParseManifest == nil {
+		h.ParseManifest = model.ParseManifestContext
+	}
+	if h.PrefetchInit == nil {
+		h.PrefetchInit = downloader.PrefetchInit
+	}
+	if h.DownloadStream == nil {
+		h.DownloadStream = downloader.DownloadStream
+	}
+	if h.MergeAudioVideo == nil {
+		h.MergeAudioVideo = merger.MergeAudioVideo
+	}
+	if h.RemuxAudioVideo == nil {
+		h.RemuxAudioVideo = merger.RemuxAudioVideo
+	}
+	if h.TranscodeAudio == nil {
+		h.TranscodeAudio = merger.TranscodeAudio
+	}
+	if h.TonemapSDR == nil {
+		h.TonemapSDR = merger.TonemapSDR
+	}
+	if h.CheckSync == nil {
+		h.CheckSync = merger.CheckSync
+	}
+	if h.LookPath == nil {
+		h.LookPath = exec.LookPath
+	}
+	if h.Inspect == nil {
+		h.Inspect = merger.Inspect
+	}
+	if h.Upload == nil {
+		h.Upload = uploadSSH
+	}
+	if h.SpeedTest == nil {
+		h.SpeedTest = downloader.SpeedTest
+	}
+	if h.Stdin == nil {
+		h.Stdin = os.Stdin
+	}
+	return h
+}
+
+// Config describes a single download, independent of how it was requested.
+type Config struct {
+	// URL is the iframe, watch or manifest URL.
+	URL       string
+	OutputDir string
+	// Manifest, if set, is used instead of fetching the manifest, e.g. one
+	// rebuilt from a saved info file. ManifestURL is then the URL it came
+	// from, which segment URLs are resolved against.
+	Manifest    *model.MPD
+	ManifestURL string
+	// Filename is the output file name; empty uses the manifest title,
+	// falling back to output.mp4.
+	Filename string
+
+	Video     model.SelectionPolicy
+	Audio     model.SelectionPolicy
+	QueryMode downloader.QueryMode
+
+	// ListFormats and DumpJSON print the available formats and stop after the manifest.
+	ListFormats bool
+	DumpJSON    bool
+	// DumpSegments, "text" or "json", prints the segment URLs of the selected
+	// streams for an external download manager and stops.
+	DumpSegments string
+	// AllFormats saves every video and audio representation to its own file
+	// instead of merging the selected pair.
+	AllFormats bool
+
+	// RefetchMissing re-downloads the tail of a stream that has fewer
+	// fragments than the manifest implies, instead of failing before the merge.
+	RefetchMissing bool
+	// LowMemory downloads segments one at a time straight to disk, for
+	// devices with little RAM.
+	LowMemory bool
+	// Workers is how many segments are fetched in parallel; 0 uses the downloader's default.
+	Workers int
+	// Strict turns every warning into an error, so nothing questionable is kept.
+	Strict bool
+	// Tonemap, if "sdr", converts HDR video to SDR before merging.
+	Tonemap string
+
+	// Exec, if its Command is set, runs after a successful download.
+	Exec execHook
+
+	MaxDuration    time.Duration // Skip longer videos; 0 disables the check
+	Timeout        time.Duration // Cancel the run after this long (exit code 124); 0 disables it
+	SyncThreshold  time.Duration
+	SlowSegment    time.Duration // Log segment requests slower than this; 0 disables the log
+	EmbedSource    bool
+	RemuxFallback  bool // Retry a failed stream-copy merge with regenerated timestamps
+	ProgressSocket string
+	// ProgressWebhook, if set, receives progress events as JSON POSTs, at most
+	// one per ProgressInterval or ProgressStep percent per stream, tagged with JobID.
+	ProgressWebhook  string
+	ProgressInterval time.Duration
+	ProgressStep     float64
+	JobID            string // Empty picks a random one
+	WriteStats       bool
+	WritePages       bool
+
+	Hooks Hooks
+}
+
+// Runner executes a Config. The CLI, tests and anything else that starts a
+// download go through it, so they all share one code path.
+type Runner struct {
+	cfg    Config
+	hooks  Hooks
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewRunner returns a Runner that reports progress to stdout. Diagnostics that
+// must not mix with --dump-json output go to stderr.
+func NewRunner(cfg Config, stdout, stderr io.Writer) *Runner {
+	return &Runner{cfg: cfg, hooks: cfg.Hooks.withDefaults(), stdout: stdout, stderr: stderr}
+}
+
+// Run downloads and merges the configured video and returns the process exit
+// code. Cancelling ctx stops the download and keeps the partial data for a re-run.
+func (r *Runner) Run(ctx context.Context) (code int) {
+	cfg, hooks, stdout, stderr := r.cfg, r.hooks, r.stdout, r.stderr
+
+	crash := crashState{URL: cfg.URL, OutputDir: cfg.OutputDir, Phase: "setup"}
+	defer func() {
+		if v := recover(); v != nil {
+			code = handlePanic(stdout, crash, v, debug.Stack(), -1)
+		}
+	}()
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.Timeout, errTimedOut)
+		defer cancel()
+	}
+
+	// Only the merge needs ffmpeg, so probing and --all-formats work without it
+	if !cfg.ListFormats && !cfg.DumpJSON && cfg.DumpSegments == "" && !cfg.AllFormats {
+		if err := checkRequirements(hooks.LookPath); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error: %v\n", err)
+			return 1
+		}
+		// Strict runs can't skip the sync check, so find out before downloading
+		if _, err := hooks.LookPath("ffprobe"); err != nil && cfg.Strict {
+			_, _ = fmt.Fprintln(stdout, "Error: ffprobe not found, it is needed for the A/V sync check with --strict")
+			return 1
+		}
+		if _, err := hooks.LookPath("ssh"); err != nil && strings.HasPrefix(cfg.OutputDir, "scp://") {
+			_, _ = fmt.Fprintln(stdout, "Error: ssh not found, it is needed for scp:// output")
+			return 1
+		}
+	}
+
+	// warn reports a condition that doesn't stop the run by default. With
+	// Strict it is an error instead and warn returns true so the caller stops.
+	warn := func(format string, a ...any) bool {
+		if cfg.Strict {
+			_, _ = fmt.Fprintf(stdout, "Error: "+format+" (--strict)\n", a...)
+			return true
+		}
+		_, _ = fmt.Fprintf(stdout, "Warning: "+format+"\n", a...)
+		return false
+	}
+
+	var err error
+	manifestUrl := cfg.ManifestURL
+	if manifestUrl == "" {
+		if manifestUrl, err = extractManifestUrl(cfg.URL); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error extracting manifest URL: %v\n", err)
+			return 1
+		}
+	}
+
+	stats := &downloadStats{
+		URL:       cfg.URL,
+		VideoUID:  extractVideoUID(cfg.URL),
+		StartedAt: time.Now(),
+	}
+
+	crash.Phase = "manifest"
+	// Keep stdout clean for --dump-json and --dump-segments so it can be piped into other tools
+	info := stdout
+	if cfg.DumpJSON || cfg.DumpSegments != "" {
+		info = stderr
+	}
+	mpd := cfg.Manifest
+	if mpd != nil {
+		_, _ = fmt.Fprintf(info, "Using saved manifest for: %s\n", manifestUrl)
+	} else {
+		_, _ = fmt.Fprintf(info, "Fetching manifest from: %s\n", manifestUrl)
+		edge := &httpclient.EdgeRecorder{}
+		if mpd, err = hooks.ParseManifest(httpclient.WithEdgeRecorder(ctx, edge), manifestUrl); err != nil {
+			if ctx.Err() != nil {
+				reason := cancelReasonOf(ctx)
+				_, _ = fmt.Fprintf(stdout, "Download cancelled: %s.\n", reason.Msg)
+				return reason.Code
+			}
+			_, _ = fmt.Fprintf(stdout, "Error parsing manifest: %v\n", err)
+			return 1
+		}
+		stats.ManifestEdge = edge.Summary()
+		if s := stats.ManifestEdge.String(); s != "" {
+			_, _ = fmt.Fprintf(info, "Manifest served over %s\n", s)
+		}
+	}
+	stats.addPhase("manifest", time.Since(stats.StartedAt))
+
+	if cfg.DumpJSON {
+		if err := dumpJSON(stdout, newVideoInfo(cfg.URL, manifestUrl, mpd)); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error writing JSON: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if cfg.ListFormats {
+		printFormats(stdout, mpd.ListRepresentations())
+		return 0
+	}
+	if cfg.DumpSegments != "" {
+		lists, err := segmentLists(mpd, manifestUrl, cfg)
+		if err == nil {
+			err = printSegments(stdout, cfg.DumpSegments, lists)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error listing segments: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	totalDuration := mpd.DurationSeconds()
+	stats.Duration = totalDuration
+	if cfg.MaxDuration > 0 && totalDuration > cfg.MaxDuration.Seconds() {
+		_, _ = fmt.Fprintf(stdout, "Skipping: video is %s long, longer than --max-duration %s\n", time.Duration(totalDuration*float64(time.Second)).Round(time.Second), cfg.MaxDuration)
+		return 0
+	}
+
+	var title string
+	if mpd.ProgramInformation != nil {
+		title = mpd.ProgramInformation.Title
+	}
+	outputDir := expandOutputTemplate(cfg.OutputDir, cfg.URL, title)
+	crash.OutputDir = outputDir
+
+	// Remote outputs are built in a local temp dir and uploaded when complete.
+	// The URL is parsed before the templates are filled in, so a # or % in a
+	// title stays part of the path.
+	finalDir := outputDir
+	remote, err := parseRemoteTarget(cfg.OutputDir)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error: %v\n", err)
+		return 1
+	}
+	keepLocal := false
+	if remote != nil {
+		remote.expand(func(s string) string { return expandOutputTemplate(s, cfg.URL, title) })
+		if outputDir, err = os.MkdirTemp("", "cfs-dl-out-"); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error creating output directory: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if !keepLocal {
+				_ = os.RemoveAll(outputDir)
+			}
+		}()
+	}
+	// finalPath is where a file written to outputDir ends up
+	finalPath := func(local string) string {
+		if remote != nil {
+			return remote.URL(filepath.Base(local))
+		}
+		return local
+	}
+	// keepLocalOutput keeps the temp dir of a remote output after a failure,
+	// so the output or the ffmpeg log in it isn't lost
+	keepLocalOutput := func() {
+		if remote != nil && !keepLocal {
+			keepLocal = true
+			_, _ = fmt.Fprintf(stdout, "Output kept in %s\n", outputDir)
+		}
+	}
+
+	finalFilename := expandOutputTemplate(cfg.Filename, cfg.URL, title)
+	if finalFilename == "" {
+		finalFilename = "output.mp4"
+		if title != "" {
+			safeTitle := sanitizeFilename(title)
+			if safeTitle != "" {
+				finalFilename = safeTitle + ".mp4"
+				_, _ = fmt.Fprintf(stdout, "Using title from manifest: %s\n", finalFilename)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error creating output directory: %v\n", err)
+		return 1
+	}
+
+	outputPath := fmt.Sprintf("%s/%s", strings.TrimRight(outputDir, "/"), finalFilename)
+
+	if cfg.WritePages && !strings.HasSuffix(cfg.URL, ".mpd") {
+		pagePath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".page.html"
+		if err := writePage(ctx, cfg.URL, pagePath); err != nil {
+			if warn("failed to save page: %v", err) {
+				return 1
+			}
+		} else {
+			_, _ = fmt.Fprintf(stdout, "Saved page to %s\n", pagePath)
+		}
+	}
+
+	var progressSrv *progress.Server
+	if cfg.ProgressSocket != "" {
+		progressSrv, err = progress.Listen(cfg.ProgressSocket)
+		if err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error opening progress socket: %v\n", err)
+			return 1
+		}
+		defer func() { _ = progressSrv.Close() }()
+	}
+	var webhook *progress.Webhook
+	if cfg.ProgressWebhook != "" {
+		jobID := cfg.JobID
+		if jobID == "" {
+			jobID = newJobID()
+		}
+		webhook = progress.NewWebhook(cfg.ProgressWebhook, jobID, cfg.ProgressInterval, cfg.ProgressStep)
+		defer func() {
+			if err := webhook.Close(); err != nil && warn("progress webhook failed: %v", err) && code == 0 {
+				code = 1
+			}
+		}()
+	}
+	publish := func(ev progress.Event) {
+		if progressSrv != nil {
+			progressSrv.Publish(ev)
+		}
+		if webhook != nil {
+			webhook.Publish(ev)
+		}
+	}
+	// stopped reports that step ("Download", "Merge", ...) was cut short by ctx
+	// and returns the exit code for the reason
+	stopped := func(step string) int {
+		reason := cancelReasonOf(ctx)
+		_, _ = fmt.Fprintf(stdout, "%s cancelled: %s.\n", step, reason.Msg)
+		publish(progress.Event{Type: "cancelled", Message: reason.Name})
+		return reason.Code
+	}
+	// upload sends everything in the temp dir of a remote output, i.e. the
+	// output and its sidecars. It returns false with the exit code if that
+	// failed, keeping the local copy.
+	upload := func() (int, bool) {
+		if remote == nil {
+			return 0, true
+		}
+		entries, err := os.ReadDir(outputDir)
+		if err == nil {
+			for _, e := range entries {
+				_, _ = fmt.Fprintf(stdout, "Uploading %s to %s\n", e.Name(), remote.URL(e.Name()))
+				if err = hooks.Upload(ctx, filepath.Join(outputDir, e.Name()), remote); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			return 0, true
+		}
+		code := 1
+		if isCancellation(ctx, err) {
+			code = stopped("Upload")
+		} else {
+			_, _ = fmt.Fprintf(stdout, "Error uploading output: %v\n", err)
+		}
+		keepLocalOutput()
+		return code, false
+	}
+	workDir := workDirFor(cfg.URL)
+	streamOptions := func(kind string, init []byte) downloader.Options {
+		return downloader.Options{
+			WorkDir:   workDir,
+			Init:      init,
+			QueryMode: cfg.QueryMode,
+			LowMemory: cfg.LowMemory,
+			Workers:   cfg.Workers,
+			Progress: func(p downloader.Progress) {
+				publish(progress.Event{Type: "progress", Stream: kind, RepresentationID: p.RepresentationID, Done: p.Done, Total: p.Total, Bytes: p.Bytes})
+			},
+		}
+	}
+	// trace returns ctx set up to record the edges and segment latencies of
+	// the named stream, logging slow segments as they happen
+	trace := func(name string) (context.Context, *httpclient.EdgeRecorder, *httpclient.LatencyRecorder) {
+		edge := &httpclient.EdgeRecorder{}
+		latency := &httpclient.LatencyRecorder{Slow: cfg.SlowSegment, OnSlow: func(url string, d time.Duration) {
+			_, _ = fmt.Fprintf(stdout, "\nSlow %s segment (%.1fs): %s\n", name, d.Seconds(), url)
+		}}
+		return httpclient.WithLatencyRecorder(httpclient.WithEdgeRecorder(ctx, edge), latency), edge, latency
+	}
+	// logEdge reports which protocol and POP served a stream, if any responses
+	// were seen, and the latency spread if any segment was slow
+	logEdge := func(name string, edge *httpclient.EdgeRecorder, latency *httpclient.LatencyRecorder) {
+		if s := edge.Summary().String(); s != "" {
+			_, _ = fmt.Fprintf(stdout, "Edge for %s: %s\n", name, s)
+		}
+		if s := latency.Summary(); len(s.Slow) > 0 {
+			_, _ = fmt.Fprintf(stdout, "Segment latency for %s: %s\n", name, s)
+		}
+	}
+	// Work files are kept when something goes wrong so a re-run can pick them up
+	keepPartial := func(files ...string) {
+		if workDir == "" {
+			for _, f := range files {
+				cleanup(f)
+			}
+			return
+		}
+		_, _ = fmt.Fprintf(stdout, "Downloaded data kept in %s, re-run the same command to resume.\n", workDir)
+	}
+	// streamFailed reports a failed stream download and returns the exit code
+	streamFailed := func(kind string, err error, files ...string) int {
+		if isCancellation(ctx, err) {
+			code := stopped("Download")
+			keepPartial(files...)
+			return code
+		}
+		var pe *downloader.PanicError
+		if errors.As(err, &pe) {
+			keepPartial(files...)
+			return handlePanic(stdout, crash, pe.Value, pe.Stack, pe.Segment)
+		}
+		_, _ = fmt.Fprintf(stdout, "Error downloading %s: %v\n", kind, err)
+		publish(progress.Event{Type: "error", Message: err.Error()})
+		keepPartial(files...)
+		return 1
+	}
+
+	// initFailed reports that the init segments couldn't be fetched and
+	// returns the exit code
+	initFailed := func(err error) int {
+		if isCancellation(ctx, err) {
+			return stopped("Download")
+		}
+		var pe *downloader.PanicError
+		if errors.As(err, &pe) {
+			return handlePanic(stdout, crash, pe.Value, pe.Stack, -1)
+		}
+		_, _ = fmt.Fprintf(stdout, "Error fetching init segments: %v\n", err)
+		publish(progress.Event{Type: "error", Message: err.Error()})
+		return 1
+	}
+
+	if cfg.AllFormats {
+		// Preservation mode: keep the whole ladder as published, one file per representation
+		var infos []model.RepresentationInfo
+		var reps []*model.Representation
+		for _, info := range mpd.ListRepresentations() {
+			if info.Kind == "video" || info.Kind == "audio" {
+				infos = append(infos, info)
+				reps = append(reps, info.Representation)
+			}
+		}
+		if len(reps) == 0 {
+			_, _ = fmt.Fprintln(stdout, "Error: manifest has no video or audio representations")
+			return 1
+		}
+		stats.Output = absOutput(finalDir)
+		if mpd.ProgramInformation != nil {
+			stats.Title = mpd.ProgramInformation.Title
+		}
+
+		crash.Phase, crash.Representation = "init", ""
+		inits, err := hooks.PrefetchInit(ctx, manifestUrl, cfg.QueryMode, reps...)
+		if err != nil {
+			return initFailed(err)
+		}
+
+		base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+		for i, info := range infos {
+			crash.Phase, crash.Representation = info.Kind, info.ID
+			start := time.Now()
+			streamCtx, edge, latency := trace(info.Kind + " " + info.ID)
+			file, err := hooks.DownloadStream(streamCtx, manifestUrl, info.Representation, totalDuration, streamOptions(info.Kind, inits[i]))
+			if err != nil {
+				return streamFailed(info.Kind+" "+info.ID, err, file)
+			}
+			dest := fmt.Sprintf("%s.%s-%s.mp4", base, info.Kind, sanitizeFilename(info.ID))
+			if err := moveFile(file, dest); err != nil {
+				_, _ = fmt.Fprintf(stdout, "Error saving %s %s: %v\n", info.Kind, info.ID, err)
+				keepPartial(file)
+				return 1
+			}
+			cleanup(file) // Drops the resume state left next to the moved file
+			stats.addStream(info.Kind, info.ID, info.Bandwidth, dest, start, time.Now(), edge.Summary(), latency.Summary())
+			logEdge(info.Kind+" "+info.ID, edge, latency)
+			stats.addPhase(info.Kind+" "+info.ID, time.Since(start))
+			_, _ = fmt.Fprintf(stdout, "Saved %s\n", dest)
+		}
+		if workDir != "" {
+			_ = os.Remove(workDir)
+		}
+
+		if cfg.WriteStats {
+			stats.finish(time.Now())
+			if err := stats.write(base + ".stats.json"); err != nil && warn("failed to write stats: %v", err) {
+				return 1
+			}
+		}
+		if code, ok := upload(); !ok {
+			return code
+		}
+		if cfg.Exec.Command != "" {
+			job := execJob{Title: stats.Title, Path: finalDir, URL: cfg.URL, Duration: totalDuration}
+			if err := runExecHook(ctx, cfg.Exec, job, stdout, stderr); err != nil {
+				if isCancellation(ctx, err) {
+					return stopped("--exec command")
+				}
+				_, _ = fmt.Fprintf(stdout, "Error running --exec command: %v\n", err)
+				return 1
+			}
+		}
+		publish(progress.Event{Type: "done", Message: finalDir})
+		_, _ = fmt.Fprintf(stdout, "Successfully saved %d formats to %s\n", len(infos), finalDir)
+		_, _ = fmt.Fprintf(stdout, "Timings: %s\n", stats.phaseSummary())
+		return 0
+	}
+
+	videoRep, err := mpd.Select("video", cfg.Video)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error selecting video stream: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "Selected video stream: ID=%s, Bandwidth=%d, Height=%d (Requested: %dp)\n", videoRep.ID, videoRep.Bandwidth, videoRep.Height, cfg.Video.Height)
+	if cfg.Video.Height > 0 && videoRep.Height != cfg.Video.Height {
+		if warn("%dp is not available, falling back to %dp", cfg.Video.Height, videoRep.Height) {
+			return 1
+		}
+	}
+	hdr := mpd.HDR(videoRep)
+	if hdr != "" && cfg.Tonemap == "" {
+		if warn("video is %s HDR and will look washed out on SDR displays, use --tonemap sdr to convert it", hdr) {
+			return 1
+		}
+	}
+
+	// Unless a codec was asked for, prefer audio that plays everywhere in the
+	// output container, and convert it before merging if there is none
+	audioPolicy, autoAudio := cfg.Audio, cfg.Audio.Codec == ""
+	if autoAudio {
+		audioPolicy.Codec = merger.PreferredAudioCodec(filepath.Ext(outputPath))
+	}
+	audioRep, err := mpd.Select("audio", audioPolicy)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error selecting audio stream: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "Selected audio stream: ID=%s, Bandwidth=%d\n", audioRep.ID, audioRep.Bandwidth)
+
+	stats.Output = absOutput(finalPath(outputPath))
+	if mpd.ProgramInformation != nil {
+		stats.Title = mpd.ProgramInformation.Title
+	}
+
+	// Fetch both init segments before any media so a bad URL or expired
+	// token fails in a second instead of after the whole video stream
+	crash.Phase, crash.Representation = "init", ""
+	inits, err := hooks.PrefetchInit(ctx, manifestUrl, cfg.QueryMode, videoRep, audioRep)
+	if err != nil {
+		return initFailed(err)
+	}
+
+	crash.Phase, crash.Representation = "video", videoRep.ID
+	videoStart := time.Now()
+	videoCtx, videoEdge, videoLatency := trace("video")
+	videoFile, err := hooks.DownloadStream(videoCtx, manifestUrl, videoRep, totalDuration, streamOptions("video", inits[0]))
+	if err != nil {
+		return streamFailed("video", err, videoFile)
+	}
+	stats.addStream("video", videoRep.ID, videoRep.Bandwidth, videoFile, videoStart, time.Now(), videoEdge.Summary(), videoLatency.Summary())
+	logEdge("video", videoEdge, videoLatency)
+	stats.addPhase("video", time.Since(videoStart))
+
+	crash.Phase, crash.Representation = "audio", audioRep.ID
+	audioStart := time.Now()
+	audioCtx, audioEdge, audioLatency := trace("audio")
+	audioFile, err := hooks.DownloadStream(audioCtx, manifestUrl, audioRep, totalDuration, streamOptions("audio", inits[1]))
+	if err != nil {
+		return streamFailed("audio", err, videoFile, audioFile)
+	}
+	stats.addStream("audio", audioRep.ID, audioRep.Bandwidth, audioFile, audioStart, time.Now(), audioEdge.Summary(), audioLatency.Summary())
+	logEdge("audio", audioEdge, audioLatency)
+	stats.addPhase("audio", time.Since(audioStart))
+
+	// Catch streams that came up short (e.g. empty segment bodies served with
+	// a 200) before ffmpeg turns them into a silently truncated output
+	crash.Phase, crash.Representation = "verify", ""
+	streams := []struct {
+		kind string
+		rep  *model.Representation
+		file *string
+	}{{"video", videoRep, &videoFile}, {"audio", audioRep, &audioFile}}
+	for _, st := range streams {
+		c, err := downloader.CheckComplete(*st.file, st.rep, totalDuration)
+		if err != nil {
+			if warn("skipping completeness check for %s: %v", st.kind, err) {
+				keepPartial(videoFile, audioFile)
+				return 1
+			}
+			continue
+		}
+		// The downloader already warned that it had to probe for the last segment
+		if c.Expected == 0 && cfg.Strict {
+			_, _ = fmt.Fprintf(stdout, "Error: %s stream length can't be verified without segment timing (--strict)\n", st.kind)
+			keepPartial(videoFile, audioFile)
+			return 1
+		}
+		if c.Complete() {
+			continue
+		}
+		_, _ = fmt.Fprintf(stdout, "Warning: %s stream has %d of %d fragments\n", st.kind, c.Fragments, c.Expected)
+		if !cfg.RefetchMissing || workDir == "" {
+			_, _ = fmt.Fprintf(stdout, "Error: %s stream is incomplete, re-run with --refetch-missing to fetch the missing segments\n", st.kind)
+			keepPartial(videoFile, audioFile)
+			return 1
+		}
+
+		crash.Representation = st.rep.ID
+		if err := downloader.PrepareRefetch(*st.file, st.rep, c); err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error preparing re-fetch of %s: %v\n", st.kind, err)
+			keepPartial(videoFile, audioFile)
+			return 1
+		}
+		_, _ = fmt.Fprintf(stdout, "Re-fetching %s after %d complete segments\n", st.kind, c.Fragments)
+		*st.file, err = hooks.DownloadStream(ctx, manifestUrl, st.rep, totalDuration, streamOptions(st.kind, nil))
+		if err != nil {
+			return streamFailed(st.kind, err, videoFile, audioFile)
+		}
+		c, err = downloader.CheckComplete(*st.file, st.rep, totalDuration)
+		if err != nil {
+			_, _ = fmt.Fprintf(stdout, "Error checking %s stream after re-fetching: %v\n", st.kind, err)
+			keepPartial(videoFile, audioFile)
+			return 1
+		}
+		if !c.Complete() {
+			_, _ = fmt.Fprintf(stdout, "Error: %s stream is still incomplete after re-fetching (%d of %d fragments)\n", st.kind, c.Fragments, c.Expected)
+			keepPartial(videoFile, audioFile)
+			return 1
+		}
+	}
+
+	var metadata map[string]string
+	if cfg.EmbedSource {
+		metadata = map[string]string{
+			"comment": fmt.Sprintf("source=%s uid=%s", cfg.URL, extractVideoUID(cfg.URL)),
+		}
+	}
+
+	// The downloaded streams stay until the merge succeeds so a re-run can resume
+	mergeVideo, mergeAudio := videoFile, audioFile
+	// ffmpegFailed reports a failed or cancelled ffmpeg step and returns the exit code
+	ffmpegFailed := func(step, doing string, err error) int {
+		defer keepPartial(videoFile, audioFile)
+		if isCancellation(ctx, err) {
+			return stopped(step)
+		}
+		_, _ = fmt.Fprintf(stdout, "Error %s: %v\n", doing, err)
+		publish(progress.Event{Type: "error", Message: err.Error()})
+		keepLocalOutput()
+		return 1
+	}
+	if hdr != "" && cfg.Tonemap == "sdr" {
+		crash.Phase, crash.Representation = "tonemap", videoRep.ID
+		_, _ = fmt.Fprintf(stdout, "Video is %s HDR, converting it to SDR (this re-encodes and takes a while)\n", hdr)
+		tonemapStart := time.Now()
+		if mergeVideo, err = hooks.TonemapSDR(ctx, videoFile); err != nil {
+			return ffmpegFailed("SDR conversion", "converting video to SDR", err)
+		}
+		defer func() { _ = os.Remove(mergeVideo) }()
+		stats.addPhase("tonemap", time.Since(tonemapStart))
+	}
+	if autoAudio && !merger.AudioCompatible(audioRep.Codecs, filepath.Ext(outputPath)) {
+		crash.Phase, crash.Representation = "transcode", audioRep.ID
+		_, _ = fmt.Fprintf(stdout, "Audio codec %s plays poorly in %s files, converting it to AAC\n", audioRep.Codecs, filepath.Ext(outputPath))
+		transcodeStart := time.Now()
+		if mergeAudio, err = hooks.TranscodeAudio(ctx, audioFile); err != nil {
+			return ffmpegFailed("Audio conversion", "converting audio", err)
+		}
+		defer func() { _ = os.Remove(mergeAudio) }()
+		stats.addPhase("transcode", time.Since(transcodeStart))
+	}
+
+	crash.Phase, crash.Representation = "merge", ""
+	mergeStart := time.Now()
+	if err := hooks.MergeAudioVideo(ctx, mergeVideo, mergeAudio, outputPath, metadata); err != nil {
+		if isCancellation(ctx, err) || !cfg.RemuxFallback {
+			return ffmpegFailed("Merge", "combining video and audio", err)
+		}
+		// Timestamp and bitstream quirks that break a plain stream copy are
+		// usually fixed by letting ffmpeg regenerate the timestamps
+		_, _ = fmt.Fprintf(stdout, "Stream-copy merge failed, retrying with regenerated timestamps: %v\n", err)
+		if err := hooks.RemuxAudioVideo(ctx, mergeVideo, mergeAudio, outputPath, metadata); err != nil {
+			return ffmpegFailed("Merge", "combining video and audio", err)
+		}
+	}
+	stats.addPhase("merge", time.Since(mergeStart))
+	cleanup(videoFile)
+	cleanup(audioFile)
+	if workDir != "" {
+		_ = os.Remove(workDir) // Only succeeds once empty
+	}
+
+	// An output that may be out of sync isn't kept in strict mode
+	syncFailed := false
+	if _, err := hooks.LookPath("ffprobe"); err != nil {
+		_, _ = fmt.Fprintln(stdout, "Skipping A/V sync check: ffprobe not found")
+	} else if drift, err := hooks.CheckSync(outputPath); err != nil {
+		syncFailed = warn("A/V sync check failed: %v", err)
+	} else if drift > cfg.SyncThreshold.Seconds() {
+		syncFailed = warn("audio and video drift by %.3fs (threshold %s), output may be out of sync", drift, cfg.SyncThreshold)
+	}
+	if syncFailed {
+		_ = os.Remove(outputPath)
+		publish(progress.Event{Type: "error", Message: "A/V sync check failed"})
+		return 1
+	}
+
+	if cfg.WriteStats {
+		stats.finish(time.Now())
+		statsPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".stats.json"
+		if err := stats.write(statsPath); err != nil && warn("failed to write stats: %v", err) {
+			keepLocalOutput()
+			return 1
+		}
+	}
+	if code, ok := upload(); !ok {
+		return code
+	}
+
+	if cfg.Exec.Command != "" {
+		job := execJob{Title: stats.Title, Path: finalPath(outputPath), URL: cfg.URL, Duration: totalDuration}
+		if err := runExecHook(ctx, cfg.Exec, job, stdout, stderr); err != nil {
+			if isCancellation(ctx, err) {
+				return stopped("--exec command")
+			}
+			_, _ = fmt.Fprintf(stdout, "Error running --exec command: %v\n", err)
+			return 1
+		}
+	}
+
+	publish(progress.Event{Type: "done", Message: finalPath(outputPath)})
+	_, _ = fmt.Fprintf(stdout, "Successfully created %s\n", finalPath(outputPath))
+	_, _ = fmt.Fprintf(stdout, "Timings: %s\n", stats.phaseSummary())
+	return 0
+}
+
+// moveFile renames src to dst, copying when they are on different filesystems
+// (stream files usually live in the temp dir).
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
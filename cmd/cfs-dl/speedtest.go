@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cfs-dl/internal/config"
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/model"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runSpeedtest implements "cfs-dl speedtest", which times a few segments of a
+// video at several connection counts, to tell a slow link from a bad setup.
+func runSpeedtest(prog string, args []string, stdout, stderr io.Writer, hooks Hooks) int {
+	fs := flag.NewFlagSet(prog+" speedtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	urlPtr := fs.String("url", "", "Cloudflare Stream iframe URL to test against")
+	resolutionPtr := fs.String("resolution", "1080p", "Video resolution whose segments are fetched")
+	segmentsPtr := fs.Int("segments", 4, "Segments to fetch per round, raised to the connection count so every connection is busy")
+	connectionsPtr := fs.String("connections", "1,4,8", "Comma-separated parallel connection counts, one round each")
+	mergeQueryPtr := fs.Bool("merge-query", false, "Append the manifest URL's query parameters (e.g. signed tokens) to every segment URL, as for downloads")
+	proxyPtr := fs.String("proxy", "", "Proxy URL, as for downloads")
+	var cookies stringList
+	fs.Var(&cookies, "cookie", "Cookie to send to the manifest host as name=value; repeatable")
+	configPtr := fs.String("config", "", "Path to a JSON config file (default: "+config.DefaultPath()+")")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: %s speedtest --url <url> [options]\n", prog)
+		_, _ = fmt.Fprintf(stderr, "\nMeasures latency and throughput to the Cloudflare edge using segments of a video.\n")
+		_, _ = fmt.Fprintf(stderr, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *urlPtr == "" {
+		_, _ = fmt.Fprintln(stdout, "Error: --url is required")
+		fs.Usage()
+		return 1
+	}
+	if *segmentsPtr < 1 {
+		_, _ = fmt.Fprintln(stdout, "Error: --segments must be at least 1")
+		return 1
+	}
+	var connections []int
+	for _, s := range strings.Split(*connectionsPtr, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			_, _ = fmt.Fprintf(stdout, "Error: invalid connection count %q\n", s)
+			return 1
+		}
+		connections = append(connections, n)
+	}
+
+	cfgPath, cfgRequired := *configPtr, true
+	if cfgPath == "" {
+		cfgPath, cfgRequired = config.DefaultPath(), false
+	}
+	fileCfg, err := config.Load(cfgPath, cfgRequired)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error loading config: %v\n", err)
+		return 1
+	}
+	manifestUrl, err := extractManifestUrl(*urlPtr)
+	if err == nil {
+		err = setupClient(fileCfg, *proxyPtr, cookies, manifestUrl)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error: %v\n", err)
+		return 1
+	}
+
+	queryMode := downloader.QueryReplace
+	if *mergeQueryPtr {
+		queryMode = downloader.QueryMerge
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mpd, err := hooks.ParseManifest(ctx, manifestUrl)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error parsing manifest: %v\n", err)
+		return 1
+	}
+	rep, err := mpd.Select("video", model.SelectionPolicy{Height: parseResolution(*resolutionPtr)})
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error selecting video stream: %v\n", err)
+		return 1
+	}
+
+	// Each round gets its own segments so later rounds aren't helped by
+	// whatever the edge cached for earlier ones, and at least one per
+	// connection, or the extra connections would sit idle
+	counts := make([]int, len(connections))
+	total := 0
+	for i, n := range connections {
+		counts[i] = max(*segmentsPtr, n)
+		total += counts[i]
+	}
+	rounds := roundSegments(downloader.SampleSegments(rep, mpd.DurationSeconds(), total), counts)
+	_, _ = fmt.Fprintf(stdout, "Testing with %s (%dx%d, %dk) from %s\n\n", rep.ID, rep.Width, rep.Height, rep.Bandwidth/1000, manifestUrl)
+
+	edge := &httpclient.EdgeRecorder{}
+	ctx = httpclient.WithEdgeRecorder(ctx, edge)
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CONNECTIONS\tLATENCY\tTHROUGHPUT\tSEGMENTS\tSIZE")
+	var best downloader.SpeedResult
+	for i, n := range connections {
+		segs := rounds[i]
+		if len(segs) < n {
+			_, _ = fmt.Fprintf(stdout, "Warning: the video only has %d segments, too few to keep %d connections busy\n", len(segs), n)
+		}
+		res, err := hooks.SpeedTest(ctx, manifestUrl, rep, queryMode, segs, n)
+		if err != nil {
+			_ = tw.Flush()
+			var pe *downloader.PanicError
+			if errors.As(err, &pe) {
+				return handlePanic(stdout, crashState{URL: *urlPtr, Phase: "speedtest", Representation: rep.ID}, pe.Value, pe.Stack, pe.Segment)
+			}
+			_, _ = fmt.Fprintf(stdout, "Error testing %d connections: %v\n", n, err)
+			return 1
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s/s\t%d\t%s\n",
+			n, res.Latency.Round(time.Millisecond), formatBytes(int64(res.BytesPerSec())), res.Segments, formatBytes(res.Bytes))
+		if res.BytesPerSec() > best.BytesPerSec() {
+			best = res
+		}
+	}
+	_ = tw.Flush()
+
+	if s := edge.Summary().String(); s != "" {
+		_, _ = fmt.Fprintf(stdout, "\nEdge: %s\n", s)
+	}
+	if rep.Bandwidth > 0 {
+		realtime := best.BytesPerSec() * 8 / float64(rep.Bandwidth)
+		_, _ = fmt.Fprintf(stdout, "Fastest: %d connections, %.1fx real time for this stream. Downloads use --workers connections (default 5).\n", best.Connections, realtime)
+	}
+	return 0
+}
+
+// roundSegments deals samples out to the rounds in turn, round i getting
+// counts[i] of them, so each round spans the video. When there are too few
+// samples to give each round its own, every round gets all of them.
+func roundSegments(samples []int, counts []int) [][]int {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	rounds := make([][]int, len(counts))
+	if len(samples) < total {
+		for i := range rounds {
+			rounds[i] = samples
+		}
+		return rounds
+	}
+	for next := 0; next < total; {
+		for i, c := range counts {
+			if len(rounds[i]) < c {
+				rounds[i] = append(rounds[i], samples[next])
+				next++
+			}
+		}
+	}
+	return rounds
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"cfs-dl/internal/downloader"
+	"cfs-dl/internal/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRun_Speedtest(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("cf-ray", "8c1f2e3a4b5c6d7e-AMS")
+		_, _ = w.Write(make([]byte, 5000))
+	}))
+	defer ts.Close()
+
+	h := Hooks{
+		ParseManifest: func(ctx context.Context, url string) (*model.MPD, error) {
+			tmpl := model.SegmentTemplate{Media: "seg_$Number$.m4s", StartNumber: 1, Duration: 4, Timescale: 1}
+			return &model.MPD{
+				MediaPresentationDuration: "PT100S",
+				Period: model.Period{
+					AdaptationSets: []model.AdaptationSet{
+						{MimeType: "video/mp4", Representations: []model.Representation{{ID: "720p", Height: 720, Bandwidth: 2000000, SegmentTemplate: tmpl}}},
+					},
+				},
+			}, nil
+		},
+	}
+
+	// Keep a config file on the test machine from changing the client
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(cfgPath, []byte("{}"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "speedtest", "--url", ts.URL + "/abc/iframe", "--segments", "2", "--connections", "1,3", "--config", cfgPath}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	out := stdout.String()
+	for _, want := range []string{"Testing with 720p", "CONNECTIONS", "Edge: HTTP/1.1 from AMS", "Fastest:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	// The 3-connection round fetches 3 segments rather than leave one idle
+	if len(paths) != 5 {
+		t.Fatalf("expected 2 segments then 3, got requests %v", paths)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/abc/manifest/seg_") {
+			t.Errorf("unexpected request %s", p)
+		}
+	}
+}
+
+func TestRun_SpeedtestBadConnections(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "speedtest", "--url", "https://example.com/iframe", "--connections", "1,zero"}
+	if code := run(args, stdout, new(bytes.Buffer), Hooks{}); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), `invalid connection count "zero"`) {
+		t.Errorf("expected connection count error, got %q", stdout.String())
+	}
+}
+
+func TestRun_SpeedtestMergeQuery(t *testing.T) {
+	h := Hooks{
+		ParseManifest: func(ctx context.Context, url string) (*model.MPD, error) {
+			return simpleMPD("audio"), nil
+		},
+	}
+	var modes []downloader.QueryMode
+	h.SpeedTest = func(ctx context.Context, base string, rep *model.Representation, mode downloader.QueryMode, segments []int, connections int) (downloader.SpeedResult, error) {
+		modes = append(modes, mode)
+		return downloader.SpeedResult{Connections: connections}, nil
+	}
+
+	stdout := new(bytes.Buffer)
+	args := []string{"cfs-dl", "speedtest", "--url", "https://example.com/abc/iframe", "--connections", "1,2", "--merge-query"}
+	if code := run(args, stdout, new(bytes.Buffer), h); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	if !reflect.DeepEqual(modes, []downloader.QueryMode{downloader.QueryMerge, downloader.QueryMerge}) {
+		t.Errorf("expected QueryMerge for every round, got %v", modes)
+	}
+}
+
+func TestRoundSegments(t *testing.T) {
+	samples := []int{1, 5, 9, 13, 17, 21}
+	if got := roundSegments(samples, []int{2, 2, 2}); !reflect.DeepEqual(got[1], []int{5, 17}) {
+		t.Errorf("expected every third sample from the second, got %v", got)
+	}
+	if got := roundSegments(samples, []int{1, 2, 3}); !reflect.DeepEqual(got, [][]int{{1}, {5, 13}, {9, 17, 21}}) {
+		t.Errorf("expected each round to get its count, got %v", got)
+	}
+	if got := roundSegments([]int{1, 2}, []int{1, 1, 1}); !reflect.DeepEqual(got[2], []int{1, 2}) {
+		t.Errorf("expected all samples when there are too few, got %v", got)
+	}
+}
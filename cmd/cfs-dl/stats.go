@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cfs-dl/internal/httpclient"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// downloadStats is written next to the output with --write-stats so archive
+// audits can show how and when each file was fetched.
+type downloadStats struct {
+	URL        string    `json:"url"`
+	VideoUID   string    `json:"video_uid"`
+	Title      string    `json:"title,omitempty"`
+	Output     string    `json:"output"`             // Absolute, or an scp:// URL
+	Duration   float64   `json:"duration,omitempty"` // Seconds of video, from the manifest
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// ManifestEdge is the protocol and Cloudflare data center that served the manifest.
+	ManifestEdge       httpclient.EdgeSummary `json:"manifest_edge,omitzero"`
+	Phases             []phaseTiming          `json:"phases"`
+	Streams            []streamStats          `json:"streams"`
+	AverageBytesPerSec float64                `json:"average_bytes_per_sec"`
+}
+
+// phaseTiming is how long one step of the run took: manifest, video, audio or merge.
+type phaseTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+type streamStats struct {
+	Kind             string    `json:"kind"`
+	RepresentationID string    `json:"representation_id"`
+	Bandwidth        int       `json:"bandwidth"`
+	Bytes            int64     `json:"bytes"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	// Edge is the protocols and data centers that served the segments, so slow
+	// streams can be traced to a specific Cloudflare POP.
+	Edge httpclient.EdgeSummary `json:"edge,omitzero"`
+	// Latency is how long the segment requests took, with the slowest ones'
+	// URLs, for CDN paths that only stall now and then.
+	Latency httpclient.LatencySummary `json:"latency,omitzero"`
+}
+
+// addStream records a finished stream download, taking its size from the file on disk.
+func (s *downloadStats) addStream(kind, repID string, bandwidth int, file string, started, finished time.Time, edge httpclient.EdgeSummary, latency httpclient.LatencySummary) {
+	var size int64
+	if info, err := os.Stat(file); err == nil {
+		size = info.Size()
+	}
+	s.Streams = append(s.Streams, streamStats{
+		Kind:             kind,
+		RepresentationID: repID,
+		Bandwidth:        bandwidth,
+		Bytes:            size,
+		StartedAt:        started,
+		FinishedAt:       finished,
+		Edge:             edge,
+		Latency:          latency,
+	})
+}
+
+func (s *downloadStats) addPhase(name string, d time.Duration) {
+	s.Phases = append(s.Phases, phaseTiming{Name: name, Seconds: d.Seconds()})
+}
+
+// phaseSummary renders the phases for the final summary line, e.g. "manifest 0.3s, video 12.1s".
+func (s *downloadStats) phaseSummary() string {
+	parts := make([]string, 0, len(s.Phases))
+	for _, p := range s.Phases {
+		parts = append(parts, fmt.Sprintf("%s %.1fs", p.Name, p.Seconds))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// finish stamps the end time and computes the average speed over the stream downloads.
+func (s *downloadStats) finish(finished time.Time) {
+	s.FinishedAt = finished
+
+	var bytes int64
+	var elapsed time.Duration
+	for _, st := range s.Streams {
+		bytes += st.Bytes
+		elapsed += st.FinishedAt.Sub(st.StartedAt)
+	}
+	if elapsed > 0 {
+		s.AverageBytesPerSec = float64(bytes) / elapsed.Seconds()
+	}
+}
+
+// absOutput makes a local output path absolute, so history and prune find it
+// from any directory. Remote scp:// URLs are returned as they are.
+func absOutput(p string) string {
+	if strings.Contains(p, "://") {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+func (s *downloadStats) write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"cfs-dl/internal/httpclient"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadStats(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "video.mp4")
+	audio := filepath.Join(dir, "audio.mp4")
+	_ = os.WriteFile(video, make([]byte, 3000), 0644)
+	_ = os.WriteFile(audio, make([]byte, 1000), 0644)
+
+	start := time.Date(2025, 12, 1, 10, 0, 0, 0, time.UTC)
+	stats := &downloadStats{URL: "https://example.com/iframe", StartedAt: start}
+	edge := httpclient.EdgeSummary{Protocols: map[string]int{"HTTP/2.0": 5}, Colos: map[string]int{"AMS": 5}, LastRay: "8c1f2e3a4b5c6d7e-AMS"}
+	latency := httpclient.LatencySummary{Requests: 5, MeanSeconds: 0.2, MaxSeconds: 0.4, Histogram: []httpclient.LatencyBucket{{UpTo: "500ms", Count: 5}}}
+	stats.addStream("video", "1080p", 4000000, video, start, start.Add(1*time.Second), edge, latency)
+	stats.addStream("audio", "audio", 128000, audio, start.Add(1*time.Second), start.Add(2*time.Second), httpclient.EdgeSummary{}, httpclient.LatencySummary{})
+	stats.addPhase("manifest", 300*time.Millisecond)
+	stats.addPhase("video", 12*time.Second)
+	stats.finish(start.Add(3 * time.Second))
+
+	if got := stats.phaseSummary(); got != "manifest 0.3s, video 12.0s" {
+		t.Errorf("unexpected phase summary %q", got)
+	}
+
+	if stats.AverageBytesPerSec != 2000 {
+		t.Errorf("expected average 2000 B/s, got %f", stats.AverageBytesPerSec)
+	}
+
+	path := filepath.Join(dir, "output.stats.json")
+	if err := stats.write(path); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+	var got downloadStats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid stats JSON: %v", err)
+	}
+	if len(got.Streams) != 2 || got.Streams[0].Bytes != 3000 || got.Streams[1].Kind != "audio" {
+		t.Errorf("unexpected streams %+v", got.Streams)
+	}
+	if got.Streams[0].Edge.Colos["AMS"] != 5 || got.Streams[0].Edge.LastRay != edge.LastRay {
+		t.Errorf("unexpected video edge %+v", got.Streams[0].Edge)
+	}
+	if strings.Contains(string(data), "manifest_edge") || strings.Count(string(data), `"edge"`) != 1 {
+		t.Errorf("expected edges without responses to be omitted:\n%s", data)
+	}
+	if got.Streams[0].Latency.Requests != 5 || strings.Count(string(data), `"latency"`) != 1 {
+		t.Errorf("expected only the video latency, got %+v:\n%s", got.Streams[0].Latency, data)
+	}
+	if len(got.Phases) != 2 || got.Phases[1].Name != "video" || got.Phases[1].Seconds != 12 {
+		t.Errorf("unexpected phases %+v", got.Phases)
+	}
+	if !got.FinishedAt.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("unexpected finished_at %v", got.FinishedAt)
+	}
+}
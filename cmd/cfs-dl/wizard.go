@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"cfs-dl/internal/config"
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// isTerminal reports whether f is an interactive terminal. It's a var so
+// tests can pretend to be one.
+var isTerminal = func(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// wizard asks for the common defaults and writes them to a config file.
+type wizard struct {
+	in       *bufio.Reader
+	out      io.Writer
+	lookPath func(string) (string, error)
+	// install runs a package manager command; tests replace it
+	install func(args []string) error
+}
+
+func newWizard(in io.Reader, out io.Writer, lookPath func(string) (string, error)) *wizard {
+	return &wizard{
+		in:       bufio.NewReader(in),
+		out:      out,
+		lookPath: lookPath,
+		install: func(args []string) error {
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, out, out
+			return cmd.Run()
+		},
+	}
+}
+
+// runSetup implements "cfs-dl setup", which runs the wizard on demand.
+func runSetup(prog string, args []string, stdout, stderr io.Writer, hooks Hooks) int {
+	fs := flag.NewFlagSet(prog+" setup", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPtr := fs.String("config", config.DefaultPath(), "Config file to write")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *configPtr == "" {
+		_, _ = fmt.Fprintln(stdout, "Error: no config directory found, pass --config")
+		return 1
+	}
+
+	if _, err := newWizard(hooks.Stdin, stdout, hooks.LookPath).run(*configPtr); err != nil {
+		_, _ = fmt.Fprintf(stdout, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// offerSetup runs the wizard on the first interactive run, i.e. when there is
+// no config file yet. Declining writes an empty config so it isn't offered again.
+func offerSetup(path string, in io.Reader, out io.Writer, lookPath func(string) (string, error)) {
+	w := newWizard(in, out, lookPath)
+	if !w.confirm(fmt.Sprintf("No config file found at %s. Set up defaults now?", path), true) {
+		if err := config.Save(path, &config.Config{}); err != nil {
+			_, _ = fmt.Fprintf(out, "Warning: %v\n", err)
+		}
+		_, _ = fmt.Fprintln(out, "Skipped. Run \"cfs-dl setup\" any time to set defaults.")
+		return
+	}
+	if _, err := w.run(path); err != nil {
+		_, _ = fmt.Fprintf(out, "Warning: setup failed: %v\n", err)
+	}
+}
+
+// run asks the questions and saves the answers to path. Settings in an
+// existing config that the wizard doesn't ask about, such as header rules and
+// the proxy, are kept, and its answers are offered as the defaults.
+func (w *wizard) run(path string) (*config.Config, error) {
+	cfg, err := config.Load(path, false)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(w.out, "Setting up cfs-dl. Press Enter to keep the value in brackets.\n\n")
+
+	cfg.OutputDir = w.ask("Default output directory", cmp.Or(cfg.OutputDir, "data/download"))
+	for {
+		cfg.Resolution = w.ask("Preferred resolution (e.g. 1080p, 720p)", cmp.Or(cfg.Resolution, "1080p"))
+		if _, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(cfg.Resolution), "p")); err == nil {
+			break
+		}
+		_, _ = fmt.Fprintf(w.out, "%q is not a resolution like 720p.\n", cfg.Resolution)
+	}
+	for {
+		n, err := strconv.Atoi(w.ask("Parallel segment downloads", strconv.Itoa(cmp.Or(cfg.Workers, 5))))
+		if err == nil && n > 0 {
+			cfg.Workers = n
+			break
+		}
+		_, _ = fmt.Fprintln(w.out, "Please enter a whole number above 0.")
+	}
+
+	if p, err := w.lookPath("ffmpeg"); err == nil {
+		_, _ = fmt.Fprintf(w.out, "Found ffmpeg at %s.\n", p)
+	} else if args := ffmpegInstallCommand(runtime.GOOS, w.lookPath); args == nil {
+		_, _ = fmt.Fprintln(w.out, "ffmpeg is not installed and no supported package manager was found. Install it from https://ffmpeg.org/download.html before downloading.")
+	} else if w.confirm(fmt.Sprintf("ffmpeg is not installed. Install it now with %q?", strings.Join(args, " ")), true) {
+		if err := w.install(args); err != nil {
+			_, _ = fmt.Fprintf(w.out, "Installing ffmpeg failed: %v. Install it manually before downloading.\n", err)
+		}
+	}
+
+	if err := config.Save(path, cfg); err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(w.out, "\nSaved settings to %s. Run \"cfs-dl setup\" to change them.\n\n", path)
+	return cfg, nil
+}
+
+// ask prints question and returns the trimmed answer, or def for an empty one.
+// A closed input counts as empty so the defaults are taken.
+func (w *wizard) ask(question, def string) string {
+	_, _ = fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	line, _ := w.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+func (w *wizard) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	switch strings.ToLower(w.ask(question, hint)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// ffmpegInstallCommand returns the command that installs ffmpeg with the first
+// package manager found for goos, or nil if there is none.
+func ffmpegInstallCommand(goos string, lookPath func(string) (string, error)) []string {
+	var candidates [][]string
+	switch goos {
+	case "darwin":
+		candidates = [][]string{{"brew", "install", "ffmpeg"}, {"port", "install", "ffmpeg"}}
+	case "windows":
+		candidates = [][]string{{"winget", "install", "--id", "Gyan.FFmpeg", "-e"}, {"choco", "install", "ffmpeg", "-y"}, {"scoop", "install", "ffmpeg"}}
+	default:
+		candidates = [][]string{
+			{"apt-get", "install", "-y", "ffmpeg"},
+			{"dnf", "install", "-y", "ffmpeg"},
+			{"pacman", "-S", "--noconfirm", "ffmpeg"},
+			{"apk", "add", "ffmpeg"},
+			{"brew", "install", "ffmpeg"},
+		}
+	}
+
+	for _, c := range candidates {
+		if _, err := lookPath(c[0]); err != nil {
+			continue
+		}
+		// System package managers need root; Homebrew refuses to run as root
+		if goos != "windows" && c[0] != "brew" && os.Geteuid() != 0 {
+			if _, err := lookPath("sudo"); err == nil {
+				return append([]string{"sudo"}, c...)
+			}
+		}
+		return c
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"cfs-dl/internal/httpclient"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config holds the settings read from the JSON config file.
+type Config struct {
+	HeaderRules []httpclient.HeaderRule `json:"header_rules,omitempty"`
+	Proxy       string                  `json:"proxy,omitempty"`
+	Dial        Dial                    `json:"dial,omitzero"`
+
+	// Defaults for --output-dir, --resolution and --workers; the flags win when given.
+	OutputDir  string `json:"output_dir,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	Workers    int    `json:"workers,omitempty"`
+}
+
+// Dial holds connection tuning for flaky links, e.g. {"timeout": "10s"}.
+type Dial struct {
+	Timeout       Duration `json:"timeout,omitzero"`
+	KeepAlive     Duration `json:"keep_alive,omitzero"`
+	FallbackDelay Duration `json:"fallback_delay,omitzero"`
+}
+
+// Options converts the dial settings for httpclient.
+func (d Dial) Options() httpclient.DialOptions {
+	return httpclient.DialOptions{
+		Timeout:       time.Duration(d.Timeout),
+		KeepAlive:     time.Duration(d.KeepAlive),
+		FallbackDelay: time.Duration(d.FallbackDelay),
+	}
+}
+
+// Duration is a time.Duration written as a string like "1m30s" in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultPath returns the config file location used when --config is not set,
+// e.g. ~/.config/cfs-dl/config.json on Linux.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cfs-dl", "config.json")
+}
+
+// Load reads the config file at path. A missing file is only an error when
+// required is true, so running without any config file keeps working.
+func Load(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Save writes cfg to path, creating its directory. The file is only readable
+// by the user since it can hold proxy credentials and auth headers.
+func Save(path string, cfg *Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
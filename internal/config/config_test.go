@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"header_rules": [{"host": "*.mycdn.example", "headers": {"Authorization": "Bearer abc"}}], "proxy": "http://proxy:3128"}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.HeaderRules) != 1 {
+		t.Fatalf("expected 1 header rule, got %d", len(cfg.HeaderRules))
+	}
+	if cfg.HeaderRules[0].Host != "*.mycdn.example" {
+		t.Errorf("expected host *.mycdn.example, got %s", cfg.HeaderRules[0].Host)
+	}
+	if cfg.HeaderRules[0].Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("expected Authorization header, got %v", cfg.HeaderRules[0].Headers)
+	}
+	if cfg.Proxy != "http://proxy:3128" {
+		t.Errorf("expected proxy http://proxy:3128, got %s", cfg.Proxy)
+	}
+}
+
+func TestLoad_Missing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+
+	if _, err := Load(path, false); err != nil {
+		t.Errorf("expected no error for optional missing config, got %v", err)
+	}
+	if _, err := Load(path, true); err == nil {
+		t.Error("expected error for required missing config, got nil")
+	}
+}
+
+func TestLoad_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(path, false); err == nil {
+		t.Error("expected error on malformed config, got nil")
+	}
+}
+
+func TestLoad_Dial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"dial": {"timeout": "10s", "keep_alive": "-1s", "fallback_delay": "1.5s"}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	opts := cfg.Dial.Options()
+	if opts.Timeout != 10*time.Second || opts.KeepAlive != -time.Second || opts.FallbackDelay != 1500*time.Millisecond {
+		t.Errorf("unexpected dial options %+v", opts)
+	}
+}
+
+func TestLoad_BadDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"dial": {"timeout": 10}}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(path, true); err == nil {
+		t.Error("expected error for numeric duration, got nil")
+	}
+}
+
+func TestSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfs-dl", "config.json")
+	cfg := &Config{
+		Proxy:      "http://proxy:3128",
+		Dial:       Dial{Timeout: Duration(10 * time.Second)},
+		OutputDir:  "/srv/videos",
+		Resolution: "720p",
+		Workers:    8,
+	}
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("config not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected mode 0600, got %o", perm)
+	}
+
+	got, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got.Proxy != cfg.Proxy || got.Dial.Timeout != cfg.Dial.Timeout || got.OutputDir != "/srv/videos" || got.Resolution != "720p" || got.Workers != 8 {
+		t.Errorf("round trip changed config: %+v", got)
+	}
+}
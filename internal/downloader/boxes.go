@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// walkBoxes calls fn for each top-level ISO BMFF box in r, in order, with the
+// box type and the offset where the box ends. Payloads are skipped, not read,
+// so this is cheap even on multi-gigabyte stream files. It stops at the first
+// box that runs past the end of r and reports it as an error.
+func walkBoxes(r io.ReadSeeker, fn func(boxType string, end int64) error) error {
+	length, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var pos int64
+	var header [16]byte
+	for pos < length {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if length-pos < 8 {
+			return fmt.Errorf("truncated box header")
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0: // Box extends to the end of the file
+			size = length - pos
+		case 1: // 64-bit size follows the type
+			if length-pos < 16 {
+				return fmt.Errorf("truncated box header")
+			}
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return err
+			}
+			size, headerLen = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerLen || size > length-pos {
+			return fmt.Errorf("invalid size for %q box", boxType)
+		}
+
+		pos += size
+		if err := fn(boxType, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
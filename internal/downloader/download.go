@@ -1,52 +1,212 @@
 package downloader
 
 import (
+	"cfs-dl/internal/httpclient"
 	"cfs-dl/internal/model"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 )
 
+// Options tunes a single DownloadStream call. The zero value uses the defaults.
+type Options struct {
+	// Progress, if set, is called after each segment is written to the output file.
+	Progress func(Progress)
+	// WorkDir, if set, holds the stream file under a name derived from the
+	// representation ID instead of a random temp name. Progress is recorded
+	// next to it so a later call with the same WorkDir resumes where this one stopped.
+	WorkDir string
+	// Init, if set, is the already fetched init segment (see PrefetchInit).
+	Init []byte
+	// QueryMode controls how the manifest's query string is carried over to segment URLs.
+	QueryMode QueryMode
+	// Workers is how many segments are fetched in parallel; 0 means 5.
+	Workers int
+	// LowMemory fetches one segment at a time and streams it straight into the
+	// output file, so memory use stays flat regardless of segment size.
+	LowMemory bool
+}
+
+// QueryMode selects how segment URLs are resolved against the manifest URL.
+type QueryMode int
+
+const (
+	// QueryReplace resolves per RFC 3986: the segment's own query, or none,
+	// replaces the manifest's.
+	QueryReplace QueryMode = iota
+	// QueryMerge also appends manifest query parameters the segment URL doesn't
+	// set itself, for signed setups where every segment needs the manifest's token.
+	QueryMerge
+)
+
+// Progress reports how far a stream download has got.
+type Progress struct {
+	RepresentationID string
+	Done             int
+	Total            int
+	Bytes            int64 // Size of the stream file so far, including the init segment
+}
+
 // DownloadStream downloads all segments for a given representation and merges them into a temporary file.
 // Returns the path to the temporary file.
-func DownloadStream(ctx context.Context, baseUrl string, rep *model.Representation, totalDurationSecs float64) (string, error) {
+func DownloadStream(ctx context.Context, baseUrl string, rep *model.Representation, totalDurationSecs float64, opts Options) (string, error) {
 	fmt.Printf("Starting download for stream: %s (bandwidth: %d)\n", rep.ID, rep.Bandwidth)
 
-	// Create a temp file to store the merged output
-	tmpFile, err := os.CreateTemp("", fmt.Sprintf("stream-%s-*.mp4", rep.ID))
+	tmpFile, err := openStreamFile(opts.WorkDir, rep.ID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer func() { _ = tmpFile.Close() }()
 
-	// 1. Download Initialization Segment
-	initUrl, err := resolveSegmentUrl(baseUrl, rep.SegmentTemplate.Initialization, rep.ID)
-	if err != nil {
-		return "", fmt.Errorf("failed to resolve init segment url: %w", err)
+	startNum := rep.SegmentTemplate.StartNumber
+	nextToWrite := startNum
+	var written int64
+
+	st, resumed := resumeState{}, false
+	if opts.WorkDir != "" {
+		st, resumed = loadState(tmpFile.Name())
 	}
 
-	fmt.Printf("Downloading init segment: %s\n", initUrl)
-	if err := downloadAndAppend(ctx, initUrl, tmpFile); err != nil {
-		return "", fmt.Errorf("failed to download init segment: %w", err)
+	if resumed {
+		// Drop anything past the last complete segment and continue from there
+		if err := tmpFile.Truncate(st.Offset); err != nil {
+			return "", fmt.Errorf("failed to truncate partial file: %w", err)
+		}
+		if _, err := tmpFile.Seek(st.Offset, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek partial file: %w", err)
+		}
+		nextToWrite, written = st.Next, st.Offset
+		fmt.Printf("Resuming %s from segment %d (%d bytes already downloaded)\n", rep.ID, nextToWrite, written)
+	} else {
+		if err := tmpFile.Truncate(0); err != nil {
+			return "", fmt.Errorf("failed to truncate file: %w", err)
+		}
+
+		// 1. Download Initialization Segment
+		if opts.Init != nil {
+			if _, err := tmpFile.Write(opts.Init); err != nil {
+				return "", fmt.Errorf("failed to write init segment: %w", err)
+			}
+		} else {
+			initUrl, err := resolveSegmentUrl(baseUrl, expandTemplate(rep.SegmentTemplate.Initialization, rep, 0), opts.QueryMode)
+			if err != nil {
+				return "", fmt.Errorf("failed to resolve init segment url: %w", err)
+			}
+
+			fmt.Printf("Downloading init segment: %s\n", initUrl)
+			if err := downloadAndAppend(ctx, initUrl, tmpFile); err != nil {
+				return "", fmt.Errorf("failed to download init segment: %w", err)
+			}
+		}
+
+		if written, err = tmpFile.Seek(0, io.SeekCurrent); err != nil {
+			return "", fmt.Errorf("failed to read file offset: %w", err)
+		}
+		if opts.WorkDir != "" {
+			if err := saveState(tmpFile.Name(), resumeState{Next: nextToWrite, Offset: written}); err != nil {
+				return "", fmt.Errorf("failed to save resume state: %w", err)
+			}
+		}
+	}
+
+	// recordNext marks the next segment, n bytes long, as written and records
+	// progress. total is 0 when the segment count isn't known up front.
+	recordNext := func(n int64, total int) error {
+		written += n
+		nextToWrite++
+		if opts.WorkDir != "" {
+			if err := saveState(tmpFile.Name(), resumeState{Next: nextToWrite, Offset: written}); err != nil {
+				return fmt.Errorf("failed to save resume state: %w", err)
+			}
+		}
+		if total > 0 {
+			fmt.Printf("\rDownloaded %d/%d segments...", nextToWrite-startNum, total)
+		} else {
+			fmt.Printf("\rDownloaded %d segments...", nextToWrite-startNum)
+		}
+		if opts.Progress != nil {
+			opts.Progress(Progress{RepresentationID: rep.ID, Done: nextToWrite - startNum, Total: total, Bytes: written})
+		}
+		return nil
+	}
+	// writeNext appends the next segment in order and records progress
+	writeNext := func(data []byte, total int) error {
+		if _, err := tmpFile.Write(data); err != nil {
+			return fmt.Errorf("failed to write segment %d to file: %w", nextToWrite, err)
+		}
+		return recordNext(int64(len(data)), total)
+	}
+	// fetchNext downloads and appends the next segment, streaming it to disk
+	// in low-memory mode and buffering it whole otherwise
+	fetchNext := func(total int) error {
+		if !opts.LowMemory {
+			data, err := safeDownloadSegment(ctx, baseUrl, rep, nextToWrite, opts.QueryMode)
+			if err != nil {
+				return err
+			}
+			return writeNext(data, total)
+		}
+		n, err := streamSegment(ctx, baseUrl, rep, nextToWrite, opts.QueryMode, tmpFile, written)
+		if err != nil {
+			return err
+		}
+		return recordNext(n, total)
 	}
 
 	// 2. Download Media Segments
-	// Calculate total segments based on duration
-	segDurationSecs := float64(rep.SegmentTemplate.Duration) / float64(rep.SegmentTemplate.Timescale)
-	totalSegments := int(totalDurationSecs / segDurationSecs)
-	// Add an extra segment to cover any potential rounding issues or final short segments
-	if totalDurationSecs > 0 && segDurationSecs > 0 {
-		totalSegments++
+	tmpl := rep.SegmentTemplate
+	totalSegments := segmentCount(tmpl, totalDurationSecs)
+	if totalSegments == 0 {
+		// Some manifests omit the timing needed to count segments, so fetch
+		// them one by one until the server runs out
+		fmt.Printf("Warning: manifest lacks segment timing (duration=%d, timescale=%d, total=%.2fs), probing segments until 404\n",
+			tmpl.Duration, tmpl.Timescale, totalDurationSecs)
+		for {
+			if err := fetchNext(0); err != nil {
+				if ctx.Err() != nil {
+					return tmpFile.Name(), ctx.Err()
+				}
+				var se *httpclient.StatusError
+				if errors.As(err, &se) && se.Code == http.StatusNotFound && nextToWrite > startNum {
+					break
+				}
+				return "", fmt.Errorf("failed to download segment %d: %w", nextToWrite, err)
+			}
+		}
+		fmt.Println("\nDownload complete.")
+		return tmpFile.Name(), nil
 	}
 
+	segDurationSecs := float64(tmpl.Duration) / float64(tmpl.Timescale)
 	fmt.Printf("Estimated segments: %d (Segment Duration: %.2fs)\n", totalSegments, segDurationSecs)
 
-	workerCount := 5
+	endNum := startNum + totalSegments
+
+	if opts.LowMemory {
+		for nextToWrite < endNum {
+			if err := fetchNext(totalSegments); err != nil {
+				if ctx.Err() != nil {
+					return tmpFile.Name(), ctx.Err()
+				}
+				return "", fmt.Errorf("failed to download segment %d: %w", nextToWrite, err)
+			}
+		}
+		fmt.Println("\nDownload complete.")
+		return tmpFile.Name(), nil
+	}
+
+	workerCount := opts.Workers
+	if workerCount <= 0 {
+		workerCount = 5
+	}
 
 	jobs := make(chan int, totalSegments)
 	results := make(chan segmentResult, totalSegments)
@@ -65,7 +225,7 @@ func DownloadStream(ctx context.Context, baseUrl string, rep *model.Representati
 					if !ok {
 						return
 					}
-					data, err := downloadSegment(ctx, baseUrl, rep, segNum)
+					data, err := safeDownloadSegment(ctx, baseUrl, rep, segNum, opts.QueryMode)
 					select {
 					case <-ctx.Done():
 						return
@@ -76,17 +236,13 @@ func DownloadStream(ctx context.Context, baseUrl string, rep *model.Representati
 		}()
 	}
 
-	startNum := rep.SegmentTemplate.StartNumber
-	endNum := startNum + totalSegments
-
-	for i := startNum; i < endNum; i++ {
+	for i := nextToWrite; i < endNum; i++ {
 		jobs <- i
 	}
 	close(jobs)
 
 	// Collect results and write strictly in order
 	segMap := make(map[int][]byte)
-	nextToWrite := startNum
 
 	// Wait for workers in a separate goroutine so we can close results
 	go func() {
@@ -107,53 +263,111 @@ func DownloadStream(ctx context.Context, baseUrl string, rep *model.Representati
 			if !ok {
 				break
 			}
-			if _, err := tmpFile.Write(data); err != nil {
-				return "", fmt.Errorf("failed to write segment %d to file: %w", nextToWrite, err)
-			}
 			delete(segMap, nextToWrite) // Free memory
-			nextToWrite++
-			fmt.Printf("\rDownloaded %d/%d segments...", nextToWrite-startNum, totalSegments)
+			if err := writeNext(data, totalSegments); err != nil {
+				return "", err
+			}
 		}
 	}
+	// Workers stop early on cancellation, leaving the stream incomplete
+	if nextToWrite < endNum {
+		if err := ctx.Err(); err != nil {
+			return tmpFile.Name(), err
+		}
+		return tmpFile.Name(), fmt.Errorf("download stopped at segment %d of %d", nextToWrite-startNum, totalSegments)
+	}
 	fmt.Println("\nDownload complete.")
 
 	return tmpFile.Name(), nil
 }
 
+// openStreamFile opens the file a stream is assembled into: a fixed name inside
+// workDir so it can be resumed, or a random temp file when workDir is empty.
+func openStreamFile(workDir, repID string) (*os.File, error) {
+	if workDir == "" {
+		return os.CreateTemp("", fmt.Sprintf("stream-%s-*.mp4", repID))
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(streamPath(workDir, repID), os.O_RDWR|os.O_CREATE, 0644)
+}
+
 type segmentResult struct {
 	index int
 	data  []byte
 	err   error
 }
 
-func downloadSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int) ([]byte, error) {
-	mediaUrlStr := strings.ReplaceAll(rep.SegmentTemplate.Media, "$Number$", fmt.Sprintf("%d", num))
+// PanicError is returned when a worker panics while fetching a segment, so the
+// panic reaches the caller instead of killing the process from a goroutine.
+type PanicError struct {
+	Segment int // -1 for an init segment
+	Value   any
+	Stack   []byte
+}
 
-	fullUrl, err := resolveSegmentUrl(baseUrl, mediaUrlStr, rep.ID)
-	if err != nil {
-		return nil, err
+func (e *PanicError) Error() string {
+	if e.Segment < 0 {
+		return fmt.Sprintf("panic while downloading init segment: %v", e.Value)
 	}
+	return fmt.Sprintf("panic while downloading segment %d: %v", e.Segment, e.Value)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
-	if err != nil {
-		return nil, err
-	}
+// var allows injecting panics in tests
+var fetchSegment = downloadSegment
+
+func safeDownloadSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Segment: num, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fetchSegment(ctx, baseUrl, rep, num, mode)
+}
+
+// segmentRetry is the backoff used for init and media segments. Segments can
+// be several megabytes, so each attempt gets more time than a manifest fetch.
+var segmentRetry = func() httpclient.RetryPolicy {
+	p := httpclient.DefaultRetry
+	p.Timeout = 2 * time.Minute
+	return p
+}()
 
-	resp, err := http.DefaultClient.Do(req)
+func downloadSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) ([]byte, error) {
+	mediaUrlStr := expandTemplate(rep.SegmentTemplate.Media, rep, num)
+
+	fullUrl, err := resolveSegmentUrl(baseUrl, mediaUrlStr, mode)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %s", resp.Status)
+	return httpclient.Fetch(ctx, fullUrl, segmentRetry)
+}
+
+// streamSegment copies segment num into f at offset without holding it in
+// memory, and returns its size. A retried attempt overwrites the partial copy.
+func streamSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode, f *os.File, offset int64) (int64, error) {
+	fullUrl, err := resolveSegmentUrl(baseUrl, expandTemplate(rep.SegmentTemplate.Media, rep, num), mode)
+	if err != nil {
+		return 0, err
 	}
 
-	return io.ReadAll(resp.Body)
+	var n int64
+	err = httpclient.FetchStream(ctx, fullUrl, segmentRetry, func(r io.Reader) error {
+		if err := f.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err = io.Copy(f, r)
+		return err
+	})
+	return n, err
 }
 
-func resolveSegmentUrl(base, relative, repID string) (string, error) {
-	// Preserves query parameters from the manifest URL if present
+func resolveSegmentUrl(base, relative string, mode QueryMode) (string, error) {
 	u, err := url.Parse(base)
 	if err != nil {
 		return "", err
@@ -166,25 +380,65 @@ func resolveSegmentUrl(base, relative, repID string) (string, error) {
 
 	// ResolveReference handles the "../../" relative paths correctly
 	resolved := u.ResolveReference(rel)
+	if mode == QueryMerge && u.RawQuery != "" && resolved.RawQuery != u.RawQuery {
+		resolved.RawQuery = mergeQuery(resolved.RawQuery, u.RawQuery)
+	}
 	return resolved.String(), nil
 }
 
-func downloadAndAppend(ctx context.Context, url string, w io.Writer) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return err
+// SegmentURLs returns the resolved URLs of rep's init segment and of the media
+// segments DownloadStream would fetch, for handing to an external downloader.
+// It fails when the manifest lacks the timing needed to count the segments.
+func SegmentURLs(baseUrl string, rep *model.Representation, totalDurationSecs float64, mode QueryMode) (string, []string, error) {
+	tmpl := rep.SegmentTemplate
+	total := segmentCount(tmpl, totalDurationSecs)
+	if total == 0 {
+		return "", nil, fmt.Errorf("can't count segments of %s: manifest lacks segment timing", rep.ID)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	initUrl, err := resolveSegmentUrl(baseUrl, expandTemplate(tmpl.Initialization, rep, 0), mode)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	media := make([]string, 0, total)
+	for num := tmpl.StartNumber; num < tmpl.StartNumber+total; num++ {
+		u, err := resolveSegmentUrl(baseUrl, expandTemplate(tmpl.Media, rep, num), mode)
+		if err != nil {
+			return "", nil, err
+		}
+		media = append(media, u)
+	}
+	return initUrl, media, nil
+}
+
+// mergeQuery appends the parameters of extra whose keys don't appear in raw.
+// Both strings are kept as they are otherwise, since signed URLs can break
+// when their parameters are re-encoded or reordered.
+func mergeQuery(raw, extra string) string {
+	have, _ := url.ParseQuery(raw)
+	out := raw
+	for _, part := range strings.Split(extra, "&") {
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if part == "" || have.Has(key) {
+			continue
+		}
+		if out != "" {
+			out += "&"
+		}
+		out += part
+	}
+	return out
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %s", resp.Status)
+func downloadAndAppend(ctx context.Context, url string, w io.Writer) error {
+	data, err := httpclient.Fetch(ctx, url, segmentRetry)
+	if err != nil {
+		return err
 	}
 
-	_, err = io.Copy(w, resp.Body)
+	_, err = w.Write(data)
 	return err
 }
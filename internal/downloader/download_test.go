@@ -3,18 +3,28 @@ package downloader
 import (
 	"cfs-dl/internal/model"
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Keep failure tests fast; the backoff itself is tested in httpclient
+	segmentRetry.BaseDelay, segmentRetry.MaxDelay = time.Millisecond, time.Millisecond
+	os.Exit(m.Run())
+}
+
 func TestResolveSegmentUrl(t *testing.T) {
 	tests := []struct {
 		name       string
 		baseUrl    string
 		relative   string
-		repID      string
+		mode       QueryMode
 		expected   string
 		shouldFail bool
 	}{
@@ -36,16 +46,39 @@ func TestResolveSegmentUrl(t *testing.T) {
 			relative: "segment.mp4?query=abc",
 			expected: "https://example.com/segment.mp4?query=abc",
 		},
-		// NOTE: Current implementation of resolveSegmentUrl uses url.ResolveReference
-		// If the relative URL has a query string, it replaces the base query string?
-		// Or if baseUrl is the manifest URL, we expect segments to be relative to it.
-		// Cloudflare segments often have huge query strings.
+		{
+			name:     "Replace Drops Manifest Query",
+			baseUrl:  "https://example.com/manifest.mpd?token=123",
+			relative: "segment.mp4",
+			expected: "https://example.com/segment.mp4",
+		},
+		{
+			name:     "Merge Adds Manifest Query",
+			baseUrl:  "https://example.com/manifest.mpd?token=123",
+			relative: "segment.mp4",
+			mode:     QueryMerge,
+			expected: "https://example.com/segment.mp4?token=123",
+		},
+		{
+			name:     "Merge Keeps Segment Params",
+			baseUrl:  "https://example.com/manifest.mpd?token=123&exp=99",
+			relative: "segment.mp4?query=abc&token=seg",
+			mode:     QueryMerge,
+			expected: "https://example.com/segment.mp4?query=abc&token=seg&exp=99",
+		},
+		{
+			name:     "Merge Without Manifest Query",
+			baseUrl:  "https://example.com/manifest.mpd",
+			relative: "segment.mp4?query=abc",
+			mode:     QueryMerge,
+			expected: "https://example.com/segment.mp4?query=abc",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Note: resolveSegmentUrl is unexported, but we are in the same package (whitebox test)
-			got, err := resolveSegmentUrl(tt.baseUrl, tt.relative, tt.repID)
+			got, err := resolveSegmentUrl(tt.baseUrl, tt.relative, tt.mode)
 			if (err != nil) != tt.shouldFail {
 				t.Fatalf("resolveSegmentUrl() error = %v, shouldFail %v", err, tt.shouldFail)
 			}
@@ -56,6 +89,42 @@ func TestResolveSegmentUrl(t *testing.T) {
 	}
 }
 
+func TestSegmentURLs(t *testing.T) {
+	rep := &model.Representation{
+		ID: "1080p",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "../../video/$RepresentationID$/init.mp4",
+			Media:          "../../video/$RepresentationID$/seg_$Number$.m4s",
+			StartNumber:    1,
+			Duration:       4,
+			Timescale:      1,
+		},
+	}
+	base := "https://example.com/abc/manifest/video.mpd?token=xyz"
+
+	initUrl, media, err := SegmentURLs(base, rep, 8, QueryMerge)
+	if err != nil {
+		t.Fatalf("SegmentURLs failed: %v", err)
+	}
+	if initUrl != "https://example.com/video/1080p/init.mp4?token=xyz" {
+		t.Errorf("unexpected init URL %q", initUrl)
+	}
+	// 8s at 4s per segment is 3 segments, counting the rounding extra
+	expected := []string{
+		"https://example.com/video/1080p/seg_1.m4s?token=xyz",
+		"https://example.com/video/1080p/seg_2.m4s?token=xyz",
+		"https://example.com/video/1080p/seg_3.m4s?token=xyz",
+	}
+	if strings.Join(media, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected media URLs %v", media)
+	}
+
+	rep.SegmentTemplate.Duration = 0
+	if _, _, err := SegmentURLs(base, rep, 8, QueryReplace); err == nil {
+		t.Error("expected error without segment timing, got nil")
+	}
+}
+
 func TestDownloadStream(t *testing.T) {
 	// Mock server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -93,7 +162,7 @@ func TestDownloadStream(t *testing.T) {
 	totalDuration := 3.0
 
 	ctx := context.Background()
-	filename, err := DownloadStream(ctx, ts.URL, rep, totalDuration)
+	filename, err := DownloadStream(ctx, ts.URL, rep, totalDuration, Options{})
 	if err != nil {
 		t.Fatalf("DownloadStream failed: %v", err)
 	}
@@ -110,6 +179,173 @@ func TestDownloadStream(t *testing.T) {
 	}
 }
 
+func TestDownloadStream_Progress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/init.mp4":
+			_, _ = w.Write([]byte("init"))
+		case "/media_0.mp4", "/media_1.mp4":
+			_, _ = w.Write([]byte("seg"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{
+		ID: "test_progress",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/media_$Number$.mp4",
+			Timescale:      1,
+			Duration:       2,
+		},
+	}
+
+	var events []Progress
+	opts := Options{Progress: func(p Progress) { events = append(events, p) }}
+
+	filename, err := DownloadStream(context.Background(), ts.URL, rep, 3.0, opts)
+	if err != nil {
+		t.Fatalf("DownloadStream failed: %v", err)
+	}
+	defer func() { _ = os.Remove(filename) }()
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 progress events, got %d", len(events))
+	}
+	last := events[1]
+	if last.Done != 2 || last.Total != 2 || last.Bytes != 10 || last.RepresentationID != "test_progress" {
+		t.Errorf("unexpected final progress %+v", last)
+	}
+}
+
+func TestDownloadStream_LowMemory(t *testing.T) {
+	var inFlight, maxInFlight, seg1Calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		if n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+		switch r.URL.Path {
+		case "/init.mp4":
+			_, _ = w.Write([]byte("init"))
+		case "/media_0.mp4":
+			_, _ = w.Write([]byte("s0"))
+		case "/media_1.mp4":
+			// The first attempt breaks off midway and must not leave its bytes behind
+			if seg1Calls.Add(1) == 1 {
+				w.Header().Set("Content-Length", "10")
+				_, _ = w.Write([]byte("bro"))
+				return
+			}
+			_, _ = w.Write([]byte("s1"))
+		case "/media_2.mp4":
+			_, _ = w.Write([]byte("s2"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name     string
+		tmpl     model.SegmentTemplate
+		duration float64
+	}{
+		{"timed", model.SegmentTemplate{Timescale: 1, Duration: 2}, 5.0},
+		{"probed", model.SegmentTemplate{}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			seg1Calls.Store(0)
+			rep := &model.Representation{ID: "test_low_mem", SegmentTemplate: tc.tmpl}
+			rep.SegmentTemplate.Initialization = "/init.mp4"
+			rep.SegmentTemplate.Media = "/media_$Number$.mp4"
+
+			filename, err := DownloadStream(context.Background(), ts.URL, rep, tc.duration, Options{LowMemory: true, WorkDir: t.TempDir()})
+			if err != nil {
+				t.Fatalf("DownloadStream failed: %v", err)
+			}
+
+			content, _ := os.ReadFile(filename)
+			if string(content) != "inits0s1s2" {
+				t.Errorf("expected content %q, got %q", "inits0s1s2", string(content))
+			}
+			if seg1Calls.Load() != 2 {
+				t.Errorf("expected segment 1 to be retried once, got %d calls", seg1Calls.Load())
+			}
+		})
+	}
+	if maxInFlight.Load() != 1 {
+		t.Errorf("expected one request at a time, saw %d", maxInFlight.Load())
+	}
+}
+
+func TestDownloadStream_MissingTiming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/init.mp4":
+			_, _ = w.Write([]byte("init"))
+		case "/media_1.mp4":
+			_, _ = w.Write([]byte("s1"))
+		case "/media_2.mp4":
+			_, _ = w.Write([]byte("s2"))
+		case "/media_3.mp4":
+			_, _ = w.Write([]byte("s3"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	// No Duration/Timescale: segments must be probed until the first 404
+	rep := &model.Representation{
+		ID: "test_no_timing",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/media_$Number$.mp4",
+			StartNumber:    1,
+		},
+	}
+
+	filename, err := DownloadStream(context.Background(), ts.URL, rep, 100.0, Options{})
+	if err != nil {
+		t.Fatalf("DownloadStream failed: %v", err)
+	}
+	defer func() { _ = os.Remove(filename) }()
+
+	content, _ := os.ReadFile(filename)
+	if string(content) != "inits1s2s3" {
+		t.Errorf("expected content %q, got %q", "inits1s2s3", string(content))
+	}
+}
+
+func TestDownloadStream_MissingTimingNoSegments(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/init.mp4" {
+			_, _ = w.Write([]byte("init"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{
+		ID: "test_no_segments",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/media_$Number$.mp4",
+		},
+	}
+
+	filename, err := DownloadStream(context.Background(), ts.URL, rep, 0, Options{})
+	if err == nil {
+		_ = os.Remove(filename)
+		t.Error("expected error when the first segment is missing, got nil")
+	}
+}
+
 func TestDownloadStream_Cancel(t *testing.T) {
 	// Mock server that hangs
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -131,7 +367,7 @@ func TestDownloadStream_Cancel(t *testing.T) {
 	// Cancel immediately
 	cancel()
 
-	_, err := DownloadStream(ctx, ts.URL, rep, 10.0)
+	_, err := DownloadStream(ctx, ts.URL, rep, 10.0, Options{})
 	if err == nil {
 		t.Error("expected error on cancel, got nil")
 	}
@@ -151,7 +387,7 @@ func TestDownloadStream_InitFail(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	_, err := DownloadStream(ctx, ts.URL, rep, 10.0)
+	_, err := DownloadStream(ctx, ts.URL, rep, 10.0, Options{})
 	if err == nil {
 		t.Error("expected error on init failure, got nil")
 	}
@@ -186,21 +422,55 @@ func TestDownloadStream_SegmentFail(t *testing.T) {
 	// Segment 0 OK, Segment 1 Fail.
 
 	ctx := context.Background()
-	filename, err := DownloadStream(ctx, ts.URL, rep, 11.0)
+	filename, err := DownloadStream(ctx, ts.URL, rep, 11.0, Options{})
 	if err == nil {
 		_ = os.Remove(filename)
 		t.Error("expected error when segment download fails, got nil")
 	}
 }
 
+func TestDownloadStream_WorkerPanic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("init"))
+	}))
+	defer ts.Close()
+
+	fetchSegment = func(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) ([]byte, error) {
+		panic("boom")
+	}
+	defer func() { fetchSegment = downloadSegment }()
+
+	rep := &model.Representation{
+		ID: "test_panic",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/media_$Number$.mp4",
+			StartNumber:    7,
+			Timescale:      1,
+			Duration:       10,
+		},
+	}
+
+	filename, err := DownloadStream(context.Background(), ts.URL, rep, 5.0, Options{})
+	_ = os.Remove(filename)
+
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if pe.Segment != 7 || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Errorf("unexpected panic error %+v", pe)
+	}
+}
+
 func TestResolveSegmentUrl_Fail(t *testing.T) {
 	// url.Parse fails on control characters
-	_, err := resolveSegmentUrl("http://base.com", "seg\nment.mp4", "id")
+	_, err := resolveSegmentUrl("http://base.com", "seg\nment.mp4", QueryReplace)
 	if err == nil {
 		t.Error("expected error on invalid relative url, got nil")
 	}
 
-	_, err = resolveSegmentUrl("http://ba\nse.com", "segment.mp4", "id")
+	_, err = resolveSegmentUrl("http://ba\nse.com", "segment.mp4", QueryReplace)
 	if err == nil {
 		t.Error("expected error on invalid base url, got nil")
 	}
@@ -229,7 +499,7 @@ func TestDownloadStream_SegmentNetworkFail(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	filename, err := DownloadStream(ctx, ts.URL, rep, 6.0)
+	filename, err := DownloadStream(ctx, ts.URL, rep, 6.0, Options{})
 	if err == nil {
 		_ = os.Remove(filename)
 		t.Error("expected error on segment network failure, got nil")
@@ -247,7 +517,7 @@ func TestDownloadStream_InitNetworkFail(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	_, err := DownloadStream(ctx, "http://base.com", rep, 10.0)
+	_, err := DownloadStream(ctx, "http://base.com", rep, 10.0, Options{})
 	if err == nil {
 		t.Error("expected error on init network failure, got nil")
 	}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"bytes"
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/model"
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PrefetchInit fetches and validates the init segments of reps in parallel,
+// so auth or URL-template problems surface before any media segment is
+// requested. The returned data can be handed to DownloadStream via Options.Init.
+func PrefetchInit(ctx context.Context, baseUrl string, mode QueryMode, reps ...*model.Representation) ([][]byte, error) {
+	inits := make([][]byte, len(reps))
+	errs := make([]error, len(reps))
+
+	var wg sync.WaitGroup
+	for i, rep := range reps {
+		wg.Add(1)
+		go func(i int, rep *model.Representation) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("init segment for %s: %w", rep.ID, &PanicError{Segment: -1, Value: r, Stack: debug.Stack()})
+				}
+			}()
+			data, err := fetchInit(ctx, baseUrl, rep, mode)
+			if err == nil {
+				err = validateInit(data)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("init segment for %s: %w", rep.ID, err)
+				return
+			}
+			inits[i] = data
+		}(i, rep)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inits, nil
+}
+
+// var allows injecting panics in tests
+var fetchInit = downloadInit
+
+func downloadInit(ctx context.Context, baseUrl string, rep *model.Representation, mode QueryMode) ([]byte, error) {
+	initUrl, err := resolveSegmentUrl(baseUrl, expandTemplate(rep.SegmentTemplate.Initialization, rep, 0), mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve url: %w", err)
+	}
+
+	return httpclient.Fetch(ctx, initUrl, segmentRetry)
+}
+
+// validateInit checks that data looks like an fMP4 init segment: a top-level
+// ftyp box first and a moov box somewhere after it. This catches HTML error
+// pages and truncated responses served with a 200 status.
+func validateInit(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return fmt.Errorf("got markup instead of MP4 data")
+	}
+
+	first, hasMoov := true, false
+	err := walkBoxes(bytes.NewReader(data), func(boxType string, end int64) error {
+		if first && boxType != "ftyp" {
+			return fmt.Errorf("expected ftyp box first, got %q", boxType)
+		}
+		first = false
+		if boxType == "moov" {
+			hasMoov = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if first {
+		return fmt.Errorf("empty init segment")
+	}
+	if !hasMoov {
+		return fmt.Errorf("no moov box")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"bytes"
+	"cfs-dl/internal/model"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func box(boxType string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+func validInit() []byte {
+	return append(box("ftyp", []byte("iso6")), box("moov", []byte("data"))...)
+}
+
+func TestValidateInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"valid", validInit(), ""},
+		{"empty", nil, "empty"},
+		{"html", []byte("<html><body>403</body></html>"), "markup"},
+		{"no ftyp", append(box("moov", nil), box("ftyp", nil)...), "ftyp"},
+		{"no moov", box("ftyp", []byte("iso6")), "no moov"},
+		{"truncated", validInit()[:20], "invalid size"},
+		{"size to end", append(box("ftyp", nil), 0, 0, 0, 0, 'm', 'o', 'o', 'v', 1, 2), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInit(tt.data)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrefetchInit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/video/init.mp4", "/audio/init.mp4":
+			_, _ = w.Write(validInit())
+		case "/bad/init.mp4":
+			_, _ = w.Write([]byte("<html>denied</html>"))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	rep := func(id string) *model.Representation {
+		return &model.Representation{ID: id, SegmentTemplate: model.SegmentTemplate{Initialization: "/$RepresentationID$/init.mp4"}}
+	}
+
+	inits, err := PrefetchInit(context.Background(), ts.URL, QueryReplace, rep("video"), rep("audio"))
+	if err != nil {
+		t.Fatalf("PrefetchInit failed: %v", err)
+	}
+	if len(inits) != 2 || !bytes.Equal(inits[0], validInit()) || !bytes.Equal(inits[1], validInit()) {
+		t.Errorf("unexpected init data: %v", inits)
+	}
+
+	if _, err := PrefetchInit(context.Background(), ts.URL, QueryReplace, rep("video"), rep("bad")); err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Errorf("expected error naming the bad representation, got %v", err)
+	}
+	if _, err := PrefetchInit(context.Background(), ts.URL, QueryReplace, rep("missing")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected 403 error, got %v", err)
+	}
+}
+
+func TestPrefetchInit_Panic(t *testing.T) {
+	fetchInit = func(ctx context.Context, baseUrl string, rep *model.Representation, mode QueryMode) ([]byte, error) {
+		panic("boom")
+	}
+	defer func() { fetchInit = downloadInit }()
+
+	_, err := PrefetchInit(context.Background(), "https://example.com", QueryReplace, &model.Representation{ID: "video"})
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if pe.Segment != -1 || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Errorf("unexpected panic error %+v", pe)
+	}
+}
+
+func TestDownloadStream_PrefetchedInit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "init") {
+			t.Errorf("init segment fetched again: %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte("seg"))
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{
+		ID: "prefetched",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/seg-$Number$.m4s",
+			StartNumber:    1,
+			Duration:       10,
+			Timescale:      1,
+		},
+	}
+
+	path, err := DownloadStream(context.Background(), ts.URL, rep, 5.0, Options{WorkDir: t.TempDir(), Init: []byte("INIT")})
+	if err != nil {
+		t.Fatalf("DownloadStream failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "INITseg" {
+		t.Errorf("expected INITseg, got %q", data)
+	}
+}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateSuffix is appended to a stream file's path for its resume state.
+const stateSuffix = ".state"
+
+// resumeState records how much of a stream file is complete. Segments are
+// written strictly in order, so the next segment number and the byte offset
+// where it starts are enough to continue an interrupted download.
+type resumeState struct {
+	Next   int   `json:"next"`
+	Offset int64 `json:"offset"`
+}
+
+// streamPath returns the work file for rep inside workDir.
+func streamPath(workDir string, rep string) string {
+	safe := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(rep)
+	return filepath.Join(workDir, fmt.Sprintf("stream-%s.mp4", safe))
+}
+
+// loadState returns the saved state for path if it is consistent with the
+// file on disk.
+func loadState(path string) (resumeState, bool) {
+	var st resumeState
+	data, err := os.ReadFile(path + stateSuffix)
+	if err != nil {
+		return st, false
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < st.Offset || st.Offset <= 0 {
+		return st, false
+	}
+	return st, true
+}
+
+func saveState(path string, st resumeState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+stateSuffix, data, 0644)
+}
+
+// Discard removes a stream file returned by DownloadStream together with its resume state.
+func Discard(path string) {
+	if path == "" {
+		return
+	}
+	_ = os.Remove(path)
+	_ = os.Remove(path + stateSuffix)
+}
@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"cfs-dl/internal/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDownloadStream_Resume(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	failSeg1 := true
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		fail := failSeg1
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/init.mp4":
+			_, _ = w.Write([]byte("init"))
+		case "/media_0.mp4":
+			_, _ = w.Write([]byte("s0"))
+		case "/media_1.mp4":
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte("s1"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{
+		ID: "test_resume",
+		SegmentTemplate: model.SegmentTemplate{
+			Initialization: "/init.mp4",
+			Media:          "/media_$Number$.mp4",
+			Timescale:      1,
+			Duration:       2,
+		},
+	}
+	opts := Options{WorkDir: t.TempDir()}
+
+	// Segments 0 and 1 are fetched concurrently, so segment 0 may or may not
+	// have been written before segment 1 fails; either way the rerun must finish the file.
+	if _, err := DownloadStream(context.Background(), ts.URL, rep, 3.0, opts); err == nil {
+		t.Fatal("expected first attempt to fail, got nil")
+	}
+
+	mu.Lock()
+	failSeg1 = false
+	mu.Unlock()
+
+	filename, err := DownloadStream(context.Background(), ts.URL, rep, 3.0, opts)
+	if err != nil {
+		t.Fatalf("resumed download failed: %v", err)
+	}
+	defer Discard(filename)
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if string(content) != "inits0s1" {
+		t.Errorf("expected content %q, got %q", "inits0s1", string(content))
+	}
+	if hits["/init.mp4"] != 1 {
+		t.Errorf("expected init segment to be fetched once, got %d", hits["/init.mp4"])
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	path := streamPath(t.TempDir(), "1080p")
+	if _, ok := loadState(path); ok {
+		t.Error("expected no state for a fresh path")
+	}
+
+	_ = os.WriteFile(path, []byte("init+seg"), 0644)
+	if err := saveState(path, resumeState{Next: 3, Offset: 4}); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+	st, ok := loadState(path)
+	if !ok || st.Next != 3 || st.Offset != 4 {
+		t.Errorf("unexpected state %+v (ok=%v)", st, ok)
+	}
+
+	// A state pointing past the end of the file can't be trusted
+	_ = saveState(path, resumeState{Next: 3, Offset: 100})
+	if _, ok := loadState(path); ok {
+		t.Error("expected state beyond end of file to be rejected")
+	}
+
+	Discard(path)
+	if _, err := os.Stat(path + stateSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected state file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"cfs-dl/internal/httpclient"
+	"cfs-dl/internal/model"
+	"context"
+	"io"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SpeedResult is the outcome of one SpeedTest round.
+type SpeedResult struct {
+	Connections int
+	Segments    int
+	Bytes       int64
+	Elapsed     time.Duration
+	// Latency is the median time from sending a request to receiving the
+	// response headers.
+	Latency time.Duration
+}
+
+// BytesPerSec is the combined throughput of all connections.
+func (r SpeedResult) BytesPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// SampleSegments picks up to n segment numbers of rep spread evenly over the
+// video, since the start is more likely to be cached at the edge than the
+// rest. Without segment timing the first n segments are used.
+func SampleSegments(rep *model.Representation, totalDurationSecs float64, n int) []int {
+	start := rep.SegmentTemplate.StartNumber
+	total := segmentCount(rep.SegmentTemplate, totalDurationSecs)
+	if total == 0 || total > n {
+		nums := make([]int, n)
+		for i := range nums {
+			nums[i] = start + i
+			if total > 0 {
+				nums[i] = start + i*total/n
+			}
+		}
+		return nums
+	}
+	nums := make([]int, total)
+	for i := range nums {
+		nums[i] = start + i
+	}
+	return nums
+}
+
+// SpeedTest downloads the given segments of rep over the given number of
+// parallel connections, discarding the data, and reports how fast it went.
+func SpeedTest(ctx context.Context, baseUrl string, rep *model.Representation, mode QueryMode, segments []int, connections int) (SpeedResult, error) {
+	res := SpeedResult{Connections: connections, Segments: len(segments)}
+
+	jobs := make(chan int, len(segments))
+	for _, num := range segments {
+		jobs <- num
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var firstErr error
+	latencies := make([]time.Duration, 0, len(segments))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < max(connections, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for num := range jobs {
+				n, latency, err := safeTimeSegment(ctx, baseUrl, rep, num, mode)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				res.Bytes += n
+				latencies = append(latencies, latency)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+
+	if firstErr != nil {
+		return res, firstErr
+	}
+	slices.Sort(latencies)
+	if len(latencies) > 0 {
+		res.Latency = latencies[len(latencies)/2]
+	}
+	return res, nil
+}
+
+// safeTimeSegment is timeSegment with panics returned as a *PanicError, as
+// for downloads.
+func safeTimeSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) (n int64, latency time.Duration, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Segment: num, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return timeSegment(ctx, baseUrl, rep, num, mode)
+}
+
+// var allows injecting panics in tests
+var timeSegment = measureSegment
+
+// measureSegment fetches segment num and returns its size and the time to the
+// response headers, including any retries.
+func measureSegment(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) (int64, time.Duration, error) {
+	segUrl, err := resolveSegmentUrl(baseUrl, expandTemplate(rep.SegmentTemplate.Media, rep, num), mode)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var n int64
+	var latency time.Duration
+	start := time.Now()
+	err = httpclient.FetchStream(ctx, segUrl, segmentRetry, func(r io.Reader) (err error) {
+		latency = time.Since(start)
+		n, err = io.Copy(io.Discard, r)
+		return err
+	})
+	return n, latency, err
+}
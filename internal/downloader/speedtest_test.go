@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"cfs-dl/internal/model"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSampleSegments(t *testing.T) {
+	rep := &model.Representation{SegmentTemplate: model.SegmentTemplate{StartNumber: 1, Duration: 4, Timescale: 1}}
+	tests := []struct {
+		name     string
+		duration float64
+		n        int
+		expected []int
+	}{
+		// 100s at 4s per segment is 26 segments, counting the rounding extra
+		{"Spread out", 100, 4, []int{1, 7, 14, 20}},
+		{"Fewer segments than asked", 8, 5, []int{1, 2, 3}},
+		{"No timing", 0, 3, []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SampleSegments(rep, tt.duration, tt.n); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("SampleSegments() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSpeedTest(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		if n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+		if !strings.HasPrefix(r.URL.Path, "/media_") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(make([]byte, 1000))
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{ID: "v", SegmentTemplate: model.SegmentTemplate{Media: "/media_$Number$.m4s"}}
+	res, err := SpeedTest(context.Background(), ts.URL, rep, QueryReplace, []int{1, 2, 3, 4}, 2)
+	if err != nil {
+		t.Fatalf("SpeedTest failed: %v", err)
+	}
+	if res.Bytes != 4000 || res.Segments != 4 || res.Connections != 2 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res.Latency < 20*time.Millisecond || res.BytesPerSec() <= 0 {
+		t.Errorf("expected latency of at least 20ms and a throughput, got %+v", res)
+	}
+	if maxInFlight.Load() != 2 {
+		t.Errorf("expected 2 parallel requests, saw %d", maxInFlight.Load())
+	}
+
+	rep.SegmentTemplate.Media = "/missing_$Number$.m4s"
+	if _, err := SpeedTest(context.Background(), ts.URL, rep, QueryReplace, []int{1}, 1); err == nil {
+		t.Error("expected error for missing segments, got nil")
+	}
+}
+
+func TestSpeedTest_Panic(t *testing.T) {
+	timeSegment = func(ctx context.Context, baseUrl string, rep *model.Representation, num int, mode QueryMode) (int64, time.Duration, error) {
+		panic("boom")
+	}
+	defer func() { timeSegment = measureSegment }()
+
+	_, err := SpeedTest(context.Background(), "https://example.com", &model.Representation{ID: "v"}, QueryReplace, []int{3, 4}, 2)
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Errorf("unexpected panic error %+v", pe)
+	}
+}
@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"cfs-dl/internal/model"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// widthFormat is the only format tag DASH allows on template identifiers (ISO 23009-1 5.3.9.4.4).
+var widthFormat = regexp.MustCompile(`^%0\d+d$`)
+
+// expandTemplate substitutes the DASH identifiers $Number$, $Bandwidth$,
+// $RepresentationID$ and the $$ escape in a SegmentTemplate URL. Number and
+// Bandwidth accept a printf-style width, e.g. $Number%05d$. Unknown
+// identifiers are left untouched.
+func expandTemplate(tmpl string, rep *model.Representation, number int) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '$')
+		if start == -1 {
+			b.WriteString(tmpl)
+			return b.String()
+		}
+		end := strings.IndexByte(tmpl[start+1:], '$')
+		if end == -1 {
+			b.WriteString(tmpl)
+			return b.String()
+		}
+		end += start + 1
+
+		b.WriteString(tmpl[:start])
+		ident := tmpl[start+1 : end]
+		if value, ok := expandIdentifier(ident, rep, number); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(tmpl[start : end+1])
+		}
+		tmpl = tmpl[end+1:]
+	}
+}
+
+func expandIdentifier(ident string, rep *model.Representation, number int) (string, bool) {
+	name, format, hasFormat := strings.Cut(ident, "%")
+	format = "%" + format
+	if hasFormat && !widthFormat.MatchString(format) {
+		return "", false
+	}
+
+	var value int
+	switch name {
+	case "":
+		return "$", !hasFormat
+	case "RepresentationID":
+		return rep.ID, !hasFormat
+	case "Number":
+		value = number
+	case "Bandwidth":
+		value = rep.Bandwidth
+	default:
+		return "", false
+	}
+
+	if hasFormat {
+		return fmt.Sprintf(format, value), true
+	}
+	return strconv.Itoa(value), true
+}
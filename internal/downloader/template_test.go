@@ -0,0 +1,33 @@
+package downloader
+
+import (
+	"cfs-dl/internal/model"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	rep := &model.Representation{ID: "v1", Bandwidth: 4000000}
+
+	tests := []struct {
+		tmpl     string
+		expected string
+	}{
+		{"seg_$Number$.m4s", "seg_42.m4s"},
+		{"seg_$Number%05d$.m4s", "seg_00042.m4s"},
+		{"$RepresentationID$/$Bandwidth$/seg.m4s", "v1/4000000/seg.m4s"},
+		{"$RepresentationID$/$Bandwidth%010d$.m4s", "v1/0004000000.m4s"},
+		{"price$$/seg_$Number$", "price$/seg_42"},
+		{"seg_$Time$.m4s", "seg_$Time$.m4s"},
+		{"seg_$Number%5s$.m4s", "seg_$Number%5s$.m4s"},
+		{"seg_$Number", "seg_$Number"},
+		{"init.mp4?token=abc", "init.mp4?token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			if got := expandTemplate(tt.tmpl, rep, 42); got != tt.expected {
+				t.Errorf("expandTemplate(%q) = %q, want %q", tt.tmpl, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"cfs-dl/internal/model"
+	"fmt"
+	"os"
+)
+
+// Completeness describes how much of a stream file holds whole fragments.
+// Each media segment is expected to carry exactly one moof+mdat pair, which
+// is how Cloudflare Stream packages its DASH output.
+type Completeness struct {
+	Expected  int   // Segments the manifest implies, 0 if its timing is missing
+	Fragments int   // Complete moof+mdat pairs in the file
+	ValidSize int64 // Offset just past the last complete fragment (or the init segment)
+}
+
+// Complete reports whether the file holds every expected fragment. Streams
+// without segment timing can't be checked and always count as complete.
+func (c Completeness) Complete() bool {
+	return c.Expected == 0 || c.Fragments >= c.Expected
+}
+
+// CheckComplete counts the fragments in a stream file produced by
+// DownloadStream for rep, so a silently short stream (e.g. empty or
+// truncated segment bodies served with a 200) is caught before merging.
+func CheckComplete(path string, rep *model.Representation, totalDurationSecs float64) (Completeness, error) {
+	c := Completeness{Expected: segmentCount(rep.SegmentTemplate, totalDurationSecs)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer func() { _ = f.Close() }()
+
+	pendingMoof := false
+	err = walkBoxes(f, func(boxType string, end int64) error {
+		switch boxType {
+		case "moov":
+			c.ValidSize = end
+		case "moof":
+			pendingMoof = true
+		case "mdat":
+			if pendingMoof {
+				c.Fragments++
+				c.ValidSize = end
+				pendingMoof = false
+			}
+		}
+		return nil
+	})
+	// A damaged tail only means fewer fragments; the count up to it still holds
+	if err != nil && c.ValidSize == 0 {
+		return c, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// PrepareRefetch rewinds the resume state of a stream file to just past its
+// last complete fragment, so the next DownloadStream call with the same
+// WorkDir re-fetches only the missing tail. The file must live in a WorkDir.
+func PrepareRefetch(path string, rep *model.Representation, c Completeness) error {
+	if c.ValidSize <= 0 {
+		// Nothing worth keeping; start the stream over
+		Discard(path)
+		return nil
+	}
+	return saveState(path, resumeState{Next: rep.SegmentTemplate.StartNumber + c.Fragments, Offset: c.ValidSize})
+}
+
+// segmentCount is the number of media segments DownloadStream fetches for a
+// template with known timing, or 0 when the timing is missing.
+func segmentCount(tmpl model.SegmentTemplate, totalDurationSecs float64) int {
+	if tmpl.Duration <= 0 || tmpl.Timescale <= 0 || totalDurationSecs <= 0 {
+		return 0
+	}
+	segDurationSecs := float64(tmpl.Duration) / float64(tmpl.Timescale)
+	// Add an extra segment to cover any potential rounding issues or final short segments
+	return int(totalDurationSecs/segDurationSecs) + 1
+}
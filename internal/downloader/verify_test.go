@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"bytes"
+	"cfs-dl/internal/model"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fragment(n int) []byte {
+	return append(box("moof", []byte{byte(n)}), box("mdat", []byte(fmt.Sprintf("seg%d", n)))...)
+}
+
+func TestCheckComplete(t *testing.T) {
+	rep := &model.Representation{SegmentTemplate: model.SegmentTemplate{Duration: 4, Timescale: 1}}
+	init := validInit()
+
+	tests := []struct {
+		name      string
+		data      []byte
+		fragments int
+		validSize int64
+		complete  bool
+	}{
+		{"complete", bytes.Join([][]byte{init, fragment(0), fragment(1), fragment(2)}, nil), 3, int64(len(init) + 3*len(fragment(0))), true},
+		{"short", bytes.Join([][]byte{init, fragment(0)}, nil), 1, int64(len(init) + len(fragment(0))), false},
+		{"cut mid-fragment", bytes.Join([][]byte{init, fragment(0), fragment(1)[:20]}, nil), 1, int64(len(init) + len(fragment(0))), false},
+		{"moof without mdat", bytes.Join([][]byte{init, fragment(0), box("moof", nil)}, nil), 1, int64(len(init) + len(fragment(0))), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stream.mp4")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// 10s at 4s per segment is 3 segments
+			c, err := CheckComplete(path, rep, 10)
+			if err != nil {
+				t.Fatalf("CheckComplete failed: %v", err)
+			}
+			if c.Expected != 3 || c.Fragments != tt.fragments || c.ValidSize != tt.validSize || c.Complete() != tt.complete {
+				t.Errorf("got %+v (complete=%v), want %d fragments ending at %d (complete=%v)", c, c.Complete(), tt.fragments, tt.validSize, tt.complete)
+			}
+		})
+	}
+}
+
+func TestCheckComplete_NoTiming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.mp4")
+	if err := os.WriteFile(path, validInit(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := CheckComplete(path, &model.Representation{}, 10)
+	if err != nil {
+		t.Fatalf("CheckComplete failed: %v", err)
+	}
+	if !c.Complete() {
+		t.Errorf("expected a stream without timing to count as complete, got %+v", c)
+	}
+}
+
+func TestPrepareRefetch(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/seg-%d.m4s", &n); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(fragment(n))
+	}))
+	defer ts.Close()
+
+	rep := &model.Representation{
+		ID: "refetch",
+		SegmentTemplate: model.SegmentTemplate{
+			Media:       "/seg-$Number$.m4s",
+			StartNumber: 1,
+			Duration:    4,
+			Timescale:   1,
+		},
+	}
+
+	// A stream whose last segment came back empty
+	workDir := t.TempDir()
+	path := streamPath(workDir, rep.ID)
+	short := bytes.Join([][]byte{validInit(), fragment(1), fragment(2)}, nil)
+	if err := os.WriteFile(path, short, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveState(path, resumeState{Next: 4, Offset: int64(len(short))}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := CheckComplete(path, rep, 10)
+	if err != nil || c.Complete() {
+		t.Fatalf("expected incomplete stream, got %+v, %v", c, err)
+	}
+	if err := PrepareRefetch(path, rep, c); err != nil {
+		t.Fatalf("PrepareRefetch failed: %v", err)
+	}
+
+	got, err := DownloadStream(context.Background(), ts.URL, rep, 10, Options{WorkDir: workDir})
+	if err != nil {
+		t.Fatalf("DownloadStream failed: %v", err)
+	}
+	if len(requests) != 1 || requests[0] != "/seg-3.m4s" {
+		t.Errorf("expected only the missing segment to be fetched, got %v", requests)
+	}
+	if c, err := CheckComplete(got, rep, 10); err != nil || !c.Complete() {
+		t.Errorf("expected complete stream after re-fetch, got %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,197 @@
+package httpclient
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		host     string
+		expected bool
+	}{
+		{"*.mycdn.example", "edge.mycdn.example", true},
+		{"*.mycdn.example", "mycdn.example", false},
+		{"*.mycdn.example", "other.example", false},
+		{"videodelivery.net", "videodelivery.net", true},
+		{"127.0.0.1:8080", "127.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.host, func(t *testing.T) {
+			if got := matchHost(tt.pattern, tt.host); got != tt.expected {
+				t.Errorf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNew_HeaderRules(t *testing.T) {
+	var gotAuth, gotOther string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotOther = r.Header.Get("X-Other")
+	}))
+	defer ts.Close()
+
+	client, err := New(Options{HeaderRules: []HeaderRule{
+		{Host: "127.0.0.1", Headers: map[string]string{"Authorization": "Bearer abc"}},
+		{Host: "*.mycdn.example", Headers: map[string]string{"X-Other": "nope"}},
+	}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if gotAuth != "Bearer abc" {
+		t.Errorf("expected Authorization header, got %q", gotAuth)
+	}
+	if gotOther != "" {
+		t.Errorf("expected no X-Other header, got %q", gotOther)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("expected original request to be left untouched")
+	}
+}
+
+func TestNew_ProxyAuth(t *testing.T) {
+	var gotAuth, gotHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		gotHost = r.Host
+	}))
+	defer proxy.Close()
+
+	proxyUrl := strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+	client, err := New(Options{Proxy: proxyUrl})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	resp, err := client.Get("http://videodelivery.example/manifest/video.mpd")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if gotAuth != expected {
+		t.Errorf("expected Proxy-Authorization %q, got %q", expected, gotAuth)
+	}
+	if gotHost != "videodelivery.example" {
+		t.Errorf("expected request for videodelivery.example, got %q", gotHost)
+	}
+}
+
+func TestNew_InvalidProxy(t *testing.T) {
+	if _, err := New(Options{Proxy: "ftp://host:21"}); err == nil {
+		t.Error("expected error for unsupported proxy scheme, got nil")
+	}
+	if _, err := New(Options{Proxy: "http://host:port\n"}); err == nil {
+		t.Error("expected error for malformed proxy URL, got nil")
+	}
+}
+
+func TestNew_DialTimeout(t *testing.T) {
+	client, err := New(Options{Dial: DialOptions{Timeout: 50 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// 10.255.255.1 is non-routable, so the connect attempt hangs until the dial timeout
+	start := time.Now()
+	_, err = client.Get("http://10.255.255.1/")
+	if err == nil {
+		t.Fatal("expected dial error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected dial to time out quickly, took %s", elapsed)
+	}
+}
+
+func TestNew_Cookies(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+
+	client, err := New(Options{
+		Cookies:    []string{"CloudFront-Policy=abc", "CloudFront-Signature=def"},
+		CookieURLs: []string{ts.URL + "/manifest/video.mpd"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Same server under another host name, which the cookies aren't scoped to
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	for _, u := range []string{ts.URL + "/seg/1.m4s", other + "/seg/1.m4s"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(got))
+	}
+	if got[0] != "CloudFront-Policy=abc; CloudFront-Signature=def" {
+		t.Errorf("expected both cookies on the manifest host, got %q", got[0])
+	}
+	if got[1] != "" {
+		t.Errorf("expected no cookies for another host, got %q", got[1])
+	}
+}
+
+func TestNew_CookiesManyHosts(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+
+	// A batch whose videos are served from two hosts
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	client, err := New(Options{
+		Cookies:    []string{"CloudFront-Policy=abc"},
+		CookieURLs: []string{ts.URL + "/a/manifest/video.mpd", other + "/b/manifest/video.mpd"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	for _, u := range []string{ts.URL + "/a/seg/1.m4s", other + "/b/seg/1.m4s"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+	if len(got) != 2 || got[0] != "CloudFront-Policy=abc" || got[1] != "CloudFront-Policy=abc" {
+		t.Errorf("expected the cookie on both hosts, got %q", got)
+	}
+}
+
+func TestNew_InvalidCookie(t *testing.T) {
+	if _, err := New(Options{Cookies: []string{"novalue"}, CookieURLs: []string{"https://example.com/"}}); err == nil {
+		t.Error("expected error for cookie without a value, got nil")
+	}
+	if _, err := New(Options{Cookies: []string{"a=b"}}); err == nil {
+		t.Error("expected error for missing cookie URL, got nil")
+	}
+}
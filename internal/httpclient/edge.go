@@ -0,0 +1,131 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Edge describes how a response reached us: the negotiated protocol and the
+// Cloudflare data center that served it.
+type Edge struct {
+	Proto string // e.g. "HTTP/2.0"
+	Ray   string // cf-ray header, e.g. "8c1f2e3a4b5c6d7e-AMS"
+	Colo  string // Data center code taken from the ray, e.g. "AMS"
+}
+
+// EdgeOf reads the Edge details from a response.
+func EdgeOf(resp *http.Response) Edge {
+	e := Edge{Proto: resp.Proto, Ray: resp.Header.Get("cf-ray")}
+	if _, colo, ok := strings.Cut(e.Ray, "-"); ok {
+		e.Colo = colo
+	}
+	return e
+}
+
+// EdgeRecorder tallies the edges that served the requests Fetch makes with a
+// context from WithEdgeRecorder. It is safe for concurrent use.
+type EdgeRecorder struct {
+	mu        sync.Mutex
+	protocols map[string]int
+	colos     map[string]int
+	lastRay   string
+}
+
+func (r *EdgeRecorder) record(e Edge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.protocols == nil {
+		r.protocols, r.colos = map[string]int{}, map[string]int{}
+	}
+	r.protocols[e.Proto]++
+	if e.Colo != "" {
+		r.colos[e.Colo]++
+	}
+	if e.Ray != "" {
+		r.lastRay = e.Ray
+	}
+}
+
+// Summary returns what has been recorded so far.
+func (r *EdgeRecorder) Summary() EdgeSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := EdgeSummary{LastRay: r.lastRay}
+	if len(r.protocols) > 0 {
+		s.Protocols = make(map[string]int, len(r.protocols))
+		for k, v := range r.protocols {
+			s.Protocols[k] = v
+		}
+	}
+	if len(r.colos) > 0 {
+		s.Colos = make(map[string]int, len(r.colos))
+		for k, v := range r.colos {
+			s.Colos[k] = v
+		}
+	}
+	return s
+}
+
+// EdgeSummary counts responses per protocol and per data center.
+type EdgeSummary struct {
+	Protocols map[string]int `json:"protocols,omitempty"`
+	Colos     map[string]int `json:"colos,omitempty"`
+	LastRay   string         `json:"last_cf_ray,omitempty"`
+}
+
+// String renders the summary for logs, e.g. "HTTP/2.0 from AMS (cf-ray 8c1f...-AMS)".
+// Counts are only shown when more than one value was seen.
+func (s EdgeSummary) String() string {
+	if len(s.Protocols) == 0 {
+		return ""
+	}
+	out := tally(s.Protocols)
+	if len(s.Colos) > 0 {
+		out += " from " + tally(s.Colos)
+	}
+	if s.LastRay != "" {
+		out += " (cf-ray " + s.LastRay + ")"
+	}
+	return out
+}
+
+// tally renders counts most frequent first, e.g. "AMS x12, FRA x3".
+func tally(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	if len(keys) == 1 {
+		return keys[0]
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s x%d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+type edgeKey struct{}
+
+// WithEdgeRecorder returns a context that makes Fetch record every response's Edge in r.
+func WithEdgeRecorder(ctx context.Context, r *EdgeRecorder) context.Context {
+	return context.WithValue(ctx, edgeKey{}, r)
+}
+
+func recordEdge(ctx context.Context, resp *http.Response) {
+	if r, ok := ctx.Value(edgeKey{}).(*EdgeRecorder); ok {
+		r.record(EdgeOf(resp))
+	}
+}
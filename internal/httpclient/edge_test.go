@@ -0,0 +1,46 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEdgeRecorder(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.Header().Set("cf-ray", "8c1f2e3a4b5c6d7e-AMS")
+		} else {
+			w.Header().Set("cf-ray", "9d2f3e4a5b6c7d8e-FRA")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	rec := &EdgeRecorder{}
+	ctx := WithEdgeRecorder(context.Background(), rec)
+	for i := 0; i < 3; i++ {
+		if _, err := Fetch(ctx, ts.URL, fastRetry); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+	// Requests without the recorder aren't counted
+	if _, err := Fetch(context.Background(), ts.URL, fastRetry); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	s := rec.Summary()
+	if s.Protocols["HTTP/1.1"] != 3 || s.Colos["AMS"] != 2 || s.Colos["FRA"] != 1 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	want := "HTTP/1.1 from AMS x2, FRA x1 (cf-ray 9d2f3e4a5b6c7d8e-FRA)"
+	if got := s.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := (EdgeSummary{}).String(); got != "" {
+		t.Errorf("expected empty string for no responses, got %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the histogram in LatencySummary. A
+// healthy segment takes well under a second; a bad CDN path takes tens.
+var latencyBuckets = []time.Duration{
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// maxSlowRequests caps how many slow requests a summary lists, so a path that
+// is slow throughout doesn't produce a huge stats file.
+const maxSlowRequests = 20
+
+// LatencyRecorder times the requests Fetch makes with a context from
+// WithLatencyRecorder, from sending the request to the end of the body. Every
+// attempt counts, so a timed out attempt that was retried shows up too.
+// It is safe for concurrent use.
+type LatencyRecorder struct {
+	// Slow, if positive, is the latency above which a request is listed in
+	// the summary and passed to OnSlow.
+	Slow   time.Duration
+	OnSlow func(url string, d time.Duration)
+
+	mu       sync.Mutex
+	counts   []int // Per latencyBuckets entry, plus one for anything slower
+	requests int
+	sum, max time.Duration
+	slow     []SlowRequest
+}
+
+func (r *LatencyRecorder) record(url string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.counts == nil {
+		r.counts = make([]int, len(latencyBuckets)+1)
+	}
+	r.counts[sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })]++
+	r.requests++
+	r.sum += d
+	r.max = max(r.max, d)
+
+	if r.Slow > 0 && d > r.Slow {
+		if len(r.slow) < maxSlowRequests {
+			r.slow = append(r.slow, SlowRequest{URL: url, Seconds: d.Seconds()})
+		}
+		// Called under the lock so concurrent workers' log lines don't interleave
+		if r.OnSlow != nil {
+			r.OnSlow(url, d)
+		}
+	}
+}
+
+// Summary returns what has been recorded so far.
+func (r *LatencyRecorder) Summary() LatencySummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.requests == 0 {
+		return LatencySummary{}
+	}
+	s := LatencySummary{
+		Requests:    r.requests,
+		MeanSeconds: r.sum.Seconds() / float64(r.requests),
+		MaxSeconds:  r.max.Seconds(),
+		Slow:        append([]SlowRequest(nil), r.slow...),
+	}
+	for i, n := range r.counts {
+		b := LatencyBucket{UpTo: "+Inf", Count: n}
+		if i < len(latencyBuckets) {
+			b.UpTo = latencyBuckets[i].String()
+		}
+		s.Histogram = append(s.Histogram, b)
+	}
+	return s
+}
+
+// LatencySummary describes how long requests took.
+type LatencySummary struct {
+	Requests    int             `json:"requests"`
+	MeanSeconds float64         `json:"mean_seconds"`
+	MaxSeconds  float64         `json:"max_seconds"`
+	Histogram   []LatencyBucket `json:"histogram"`
+	// Slow lists the first requests over the recorder's Slow threshold.
+	Slow []SlowRequest `json:"slow,omitempty"`
+}
+
+// LatencyBucket counts the requests that took at most UpTo, and longer than
+// the previous bucket's UpTo.
+type LatencyBucket struct {
+	UpTo  string `json:"le"` // e.g. "2s", or "+Inf" for the last bucket
+	Count int    `json:"count"`
+}
+
+// SlowRequest is a request that took longer than the recorder's Slow threshold.
+type SlowRequest struct {
+	URL     string  `json:"url"`
+	Seconds float64 `json:"seconds"`
+}
+
+// String renders the summary for logs, e.g. "120 requests, mean 0.4s, max
+// 31.2s (<=500ms: 110, <=1s: 7, >30s: 3)". Empty buckets are left out.
+func (s LatencySummary) String() string {
+	if s.Requests == 0 {
+		return ""
+	}
+	out := fmt.Sprintf("%d requests, mean %.1fs, max %.1fs", s.Requests, s.MeanSeconds, s.MaxSeconds)
+	var parts []string
+	for _, b := range s.Histogram {
+		switch {
+		case b.Count == 0:
+		case b.UpTo == "+Inf":
+			parts = append(parts, fmt.Sprintf(">%s: %d", latencyBuckets[len(latencyBuckets)-1], b.Count))
+		default:
+			parts = append(parts, fmt.Sprintf("<=%s: %d", b.UpTo, b.Count))
+		}
+	}
+	return out + " (" + strings.Join(parts, ", ") + ")"
+}
+
+type latencyKey struct{}
+
+// WithLatencyRecorder returns a context that makes Fetch time every request in r.
+func WithLatencyRecorder(ctx context.Context, r *LatencyRecorder) context.Context {
+	return context.WithValue(ctx, latencyKey{}, r)
+}
+
+func recordLatency(ctx context.Context, url string, d time.Duration) {
+	if r, ok := ctx.Value(latencyKey{}).(*LatencyRecorder); ok {
+		r.record(url, d)
+	}
+}
@@ -0,0 +1,63 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	var logged []string
+	rec := &LatencyRecorder{Slow: 30 * time.Millisecond, OnSlow: func(url string, d time.Duration) {
+		logged = append(logged, url)
+	}}
+	ctx := WithLatencyRecorder(context.Background(), rec)
+	for _, path := range []string{"/fast", "/slow", "/fast"} {
+		if _, err := Fetch(ctx, ts.URL+path, fastRetry); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+	// Requests without the recorder aren't timed
+	if _, err := Fetch(context.Background(), ts.URL+"/slow", fastRetry); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	s := rec.Summary()
+	if s.Requests != 3 || s.MaxSeconds < 0.05 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if len(s.Histogram) != len(latencyBuckets)+1 || s.Histogram[0].UpTo != "500ms" || s.Histogram[0].Count != 3 {
+		t.Errorf("expected all requests in the first bucket, got %+v", s.Histogram)
+	}
+	if len(s.Slow) != 1 || s.Slow[0].URL != ts.URL+"/slow" || len(logged) != 1 {
+		t.Errorf("expected the slow request to be listed and logged, got %+v and %v", s.Slow, logged)
+	}
+	if got := s.String(); !strings.HasPrefix(got, "3 requests, mean 0.0s, max 0.1s") || !strings.HasSuffix(got, "(<=500ms: 3)") {
+		t.Errorf("unexpected string %q", got)
+	}
+	if got := (&LatencyRecorder{}).Summary().String(); got != "" {
+		t.Errorf("expected empty string for no requests, got %q", got)
+	}
+}
+
+func TestLatencyRecorder_Buckets(t *testing.T) {
+	rec := &LatencyRecorder{}
+	for _, d := range []time.Duration{time.Second, 3 * time.Second, 45 * time.Second} {
+		rec.record("u", d)
+	}
+	want := "3 requests, mean 16.3s, max 45.0s (<=1s: 1, <=5s: 1, >30s: 1)"
+	if got := rec.Summary().String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
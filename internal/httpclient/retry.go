@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls how Fetch retries failed requests.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first one.
+	Attempts int
+	// BaseDelay is the wait before the first retry; it doubles on every
+	// further retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout bounds each attempt, including reading the body. Zero means no limit.
+	Timeout time.Duration
+}
+
+// DefaultRetry is used for manifests and segments unless a caller overrides it.
+var DefaultRetry = RetryPolicy{
+	Attempts:  4,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  8 * time.Second,
+}
+
+// StatusError is returned by Fetch for non-200 responses.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "status " + e.Status
+}
+
+// Fetch GETs rawUrl with the shared client and returns the body of a 200
+// response. Network errors, timeouts, 429 and 5xx responses are retried with
+// exponential backoff; other statuses fail immediately with a *StatusError.
+func Fetch(ctx context.Context, rawUrl string, p RetryPolicy) ([]byte, error) {
+	var data []byte
+	err := FetchStream(ctx, rawUrl, p, func(r io.Reader) (err error) {
+		data, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// FetchStream is like Fetch but hands the body of a 200 response to fn instead
+// of buffering it. fn is called again from scratch on every retry, so it must
+// discard whatever an earlier call wrote; errors it returns are retried too.
+func FetchStream(ctx context.Context, rawUrl string, p RetryPolicy, fn func(io.Reader) error) error {
+	// A malformed URL won't fix itself, so don't spend the backoff on it
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	attempts := max(p.Attempts, 1)
+	delay := p.BaseDelay
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, p.MaxDelay)
+		}
+
+		err = fetchOnce(ctx, rawUrl, p.Timeout, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+	}
+	if attempts > 1 {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+func fetchOnce(ctx context.Context, url string, timeout time.Duration, fn func(io.Reader) error) error {
+	// Attempts cut short by the caller say nothing about the server, so they aren't timed
+	parent, start := ctx, time.Now()
+	defer func() {
+		if parent.Err() == nil {
+			recordLatency(parent, url, time.Since(start))
+		}
+	}()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := shared.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	recordEdge(ctx, resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	return fn(resp.Body)
+}
+
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusTooManyRequests || se.Code >= 500
+	}
+	return true
+}
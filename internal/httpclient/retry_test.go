@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestFetch_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	data, err := Fetch(context.Background(), ts.URL, fastRetry)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if string(data) != "ok" || calls.Load() != 3 {
+		t.Errorf("got %q after %d calls, want \"ok\" after 3", data, calls.Load())
+	}
+}
+
+func TestFetch_NoRetryOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	_, err := Fetch(context.Background(), ts.URL, fastRetry)
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 StatusError, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestFetch_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	p := fastRetry
+	p.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := Fetch(context.Background(), ts.URL, p)
+	if err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %v despite per-attempt timeout", elapsed)
+	}
+}
+
+func TestFetch_Cancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := fastRetry
+	p.BaseDelay = time.Hour
+	if _, err := Fetch(ctx, ts.URL, p); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFetch_BadScheme(t *testing.T) {
+	if _, err := Fetch(context.Background(), "invalid-protocol://test", fastRetry); err == nil {
+		t.Error("expected error for unsupported scheme, got nil")
+	}
+}
+
+func TestFetchStream_RestartsOnRetry(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// Promise more than is sent so the first read fails midway
+			w.Header().Set("Content-Length", "10")
+			_, _ = w.Write([]byte("part"))
+			return
+		}
+		_, _ = w.Write([]byte("whole body"))
+	}))
+	defer ts.Close()
+
+	var starts int
+	var buf []byte
+	err := FetchStream(context.Background(), ts.URL, fastRetry, func(r io.Reader) error {
+		starts++
+		buf = buf[:0]
+		chunk := make([]byte, 3)
+		for {
+			n, err := r.Read(chunk)
+			buf = append(buf, chunk[:n]...)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("FetchStream failed: %v", err)
+	}
+	if string(buf) != "whole body" || starts != 2 {
+		t.Errorf("got %q after %d starts, want \"whole body\" after 2", buf, starts)
+	}
+}
@@ -0,0 +1,47 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+)
+
+// estimateOutputSize returns the combined size of the input streams, which a
+// stream-copy merge reproduces almost exactly.
+func estimateOutputSize(inputs ...string) int64 {
+	var total int64
+	for _, in := range inputs {
+		info, err := os.Stat(in)
+		if err != nil {
+			return 0
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+// var allows injecting errors in tests
+var fallocate = allocate
+
+// checkFreeSpace checks that size bytes fit at path before ffmpeg writes
+// there, so a full disk or quota is reported up front instead of after a
+// partial merge. It tries to allocate the blocks and nothing more: ffmpeg
+// truncates the file when it opens it, so no space stays reserved for the
+// merge. Filesystems that can't allocate ahead (NFS before 4.2, SMB, many
+// FUSE mounts) skip the check.
+func checkFreeSpace(path string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := fallocate(f, size); err != nil && diskFull(err) {
+		_ = os.Remove(path)
+		return fmt.Errorf("not enough space for %d bytes at %s: %w", size, path, err)
+	}
+	return nil
+}
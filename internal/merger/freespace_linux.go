@@ -0,0 +1,26 @@
+//go:build linux
+
+package merger
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ChecksFreeSpace reports whether free space for the output is checked before
+// merging, so a full disk is caught up front.
+const ChecksFreeSpace = true
+
+// allocate reserves size bytes of disk blocks for f, failing with ENOSPC
+// when the filesystem cannot hold them.
+func allocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
+
+// diskFull reports whether an allocate error means the space isn't there.
+// Anything else, e.g. EOPNOTSUPP from a network filesystem, only means the
+// check couldn't be done.
+func diskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
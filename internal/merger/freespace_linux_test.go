@@ -0,0 +1,29 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCheckFreeSpace_Errors(t *testing.T) {
+	t.Cleanup(func() { fallocate = allocate })
+	path := filepath.Join(t.TempDir(), "output.mp4")
+
+	// NFS before 4.2, SMB and FUSE mounts can't reserve space
+	fallocate = func(f *os.File, size int64) error { return syscall.EOPNOTSUPP }
+	if err := checkFreeSpace(path, 4096); err != nil {
+		t.Errorf("expected an unsupported filesystem to skip the check, got %v", err)
+	}
+
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT} {
+		fallocate = func(f *os.File, size int64) error { return errno }
+		if err := checkFreeSpace(path, 4096); err == nil {
+			t.Errorf("expected %v to fail the merge", errno)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected the file to be removed after %v, got %v", errno, err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package merger
+
+import "os"
+
+// ChecksFreeSpace reports whether free space for the output is checked before
+// merging, so a full disk is caught up front.
+const ChecksFreeSpace = false
+
+// allocate extends f to size bytes. Without fallocate the file may be sparse,
+// so running out of space is only detected by ffmpeg itself.
+func allocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+// diskFull reports whether an allocate error means the space isn't there.
+// A sparse file proves nothing either way, so failures never stop the merge.
+func diskFull(err error) bool {
+	return false
+}
@@ -0,0 +1,44 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateOutputSize(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "video.mp4")
+	audio := filepath.Join(dir, "audio.mp4")
+	_ = os.WriteFile(video, make([]byte, 100), 0644)
+	_ = os.WriteFile(audio, make([]byte, 20), 0644)
+
+	if got := estimateOutputSize(video, audio); got != 120 {
+		t.Errorf("estimateOutputSize() = %d, want 120", got)
+	}
+	if got := estimateOutputSize(video, filepath.Join(dir, "missing.mp4")); got != 0 {
+		t.Errorf("estimateOutputSize() with missing input = %d, want 0", got)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.mp4")
+
+	if err := checkFreeSpace(path, 4096); err != nil {
+		t.Fatalf("checkFreeSpace failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected output file to exist: %v", err)
+	}
+	if info.Size() != 4096 {
+		t.Errorf("expected size 4096, got %d", info.Size())
+	}
+}
+
+func TestCheckFreeSpace_Fail(t *testing.T) {
+	if err := checkFreeSpace("/dev/null/output.mp4", 4096); err == nil {
+		t.Error("expected error when output cannot be created, got nil")
+	}
+}
@@ -1,33 +1,213 @@
 package merger
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // var allows mocking in tests
-var execCommand = exec.Command
+var execCommand = exec.CommandContext
 
-func MergeAudioVideo(videoFile, audioFile, outputFile string) error {
+// cancelGrace is how long ffmpeg gets to exit after an interrupt before it is killed.
+const cancelGrace = 5 * time.Second
+
+// logTailLines is how many lines of ffmpeg's stderr are included in the error.
+const logTailLines = 5
+
+// MergeAudioVideo muxes the video and audio streams into outputFile, writing
+// each entry of metadata as a container tag. ffmpeg writes to a .part file
+// that is renamed once it is done, so a failed or cancelled merge never
+// leaves a half-written outputFile behind.
+func MergeAudioVideo(ctx context.Context, videoFile, audioFile, outputFile string, metadata map[string]string) error {
 	fmt.Printf("Merging video: %s and audio: %s to %s\n", videoFile, audioFile, outputFile)
 
 	// ffmpeg -i video.mp4 -i audio.mp4 -c:v copy -c:a copy output.mp4
-	cmd := execCommand("ffmpeg",
-		"-y", // Overwrite output file
-		"-i", videoFile,
-		"-i", audioFile,
+	return mux(ctx, "merge", videoFile, audioFile, outputFile, metadata, nil, nil)
+}
+
+// RemuxAudioVideo is MergeAudioVideo for streams a plain stream copy chokes
+// on, e.g. with missing or non-monotonic timestamps. ffmpeg regenerates the
+// timestamps and shifts them to start at zero; the streams are still copied,
+// not re-encoded.
+func RemuxAudioVideo(ctx context.Context, videoFile, audioFile, outputFile string, metadata map[string]string) error {
+	fmt.Printf("Remuxing video: %s and audio: %s to %s with regenerated timestamps\n", videoFile, audioFile, outputFile)
+
+	// ffmpeg -fflags +genpts+igndts -i video.mp4 -fflags +genpts+igndts -i audio.mp4
+	//   -map 0:v:0 -map 1:a:0 -c:v copy -c:a copy -avoid_negative_ts make_zero output.mp4
+	input := []string{"-fflags", "+genpts+igndts"}
+	output := []string{
+		"-map", "0:v:0",
+		"-map", "1:a:0",
+		"-avoid_negative_ts", "make_zero",
+		"-max_muxing_queue_size", "4096", // Interleaving badly timed packets needs a deep queue
+	}
+	return mux(ctx, "remux", videoFile, audioFile, outputFile, metadata, input, output)
+}
+
+// mux stream-copies videoFile and audioFile into outputFile through a .part
+// file, with extra ffmpeg options for each input and for the output.
+func mux(ctx context.Context, step, videoFile, audioFile, outputFile string, metadata map[string]string, input, output []string) error {
+	// The extension stays last so ffmpeg still picks the container from it
+	ext := filepath.Ext(outputFile)
+	partFile := strings.TrimSuffix(outputFile, ext) + ".part" + ext
+	if err := checkFreeSpace(partFile, estimateOutputSize(videoFile, audioFile)); err != nil {
+		return err
+	}
+
+	args := []string{"-y"} // Overwrite output file
+	args = append(append(args, input...), "-i", videoFile)
+	args = append(append(args, input...), "-i", audioFile)
+	args = append(args,
 		"-c:v", "copy", // Copy video stream without re-encoding
 		"-c:a", "copy", // Copy audio stream without re-encoding
-		outputFile,
 	)
+	args = append(args, output...)
+	args = append(args, metadataArgs(metadata)...)
+	args = append(args, partFile)
+	if err := runFFmpeg(ctx, step, args, outputFile+".ffmpeg.log"); err != nil {
+		_ = os.Remove(partFile)
+		return err
+	}
+	return os.Rename(partFile, outputFile)
+}
+
+// TranscodeAudio re-encodes audioFile to AAC next to it and returns the new
+// file, for containers whose players don't take the original codec.
+func TranscodeAudio(ctx context.Context, audioFile string) (string, error) {
+	outputFile := strings.TrimSuffix(audioFile, filepath.Ext(audioFile)) + ".aac.m4a"
+	fmt.Printf("Converting audio %s to AAC\n", audioFile)
+
+	// ffmpeg -i audio.mp4 -vn -c:a aac -b:a 192k audio.aac.m4a
+	args := []string{
+		"-y",
+		"-i", audioFile,
+		"-vn",
+		"-c:a", "aac",
+		"-b:a", "192k",
+		outputFile,
+	}
+	if err := runFFmpeg(ctx, "audio conversion", args, outputFile+".ffmpeg.log"); err != nil {
+		_ = os.Remove(outputFile)
+		return "", err
+	}
+	return outputFile, nil
+}
+
+// sdrFilter tonemaps PQ or HLG video to BT.709 SDR. zscale needs an ffmpeg
+// built with libzimg, which the common static builds are.
+const sdrFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+// TonemapSDR re-encodes the HDR videoFile to SDR H.264 next to it and returns
+// the new file, so it doesn't look washed out on SDR displays.
+func TonemapSDR(ctx context.Context, videoFile string) (string, error) {
+	outputFile := strings.TrimSuffix(videoFile, filepath.Ext(videoFile)) + ".sdr.mp4"
+	fmt.Printf("Converting video %s to SDR\n", videoFile)
 
+	args := []string{
+		"-y",
+		"-i", videoFile,
+		"-an",
+		"-vf", sdrFilter,
+		"-c:v", "libx264",
+		"-crf", "18",
+		"-preset", "medium",
+		outputFile,
+	}
+	if err := runFFmpeg(ctx, "tonemapping", args, outputFile+".ffmpeg.log"); err != nil {
+		_ = os.Remove(outputFile)
+		return "", err
+	}
+	return outputFile, nil
+}
+
+// PreferredAudioCodec returns the codecs prefix of the audio that plays
+// everywhere in a file with extension ext, or "" if any codec will do.
+func PreferredAudioCodec(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".mp4", ".m4v", ".mov":
+		return "mp4a"
+	}
+	return ""
+}
+
+// AudioCompatible reports whether audio in codecs (an MPD codecs attribute)
+// can be copied as is into a file with extension ext. MP4 can technically
+// hold Opus, Vorbis and FLAC, but many TVs and older players refuse them.
+func AudioCompatible(codecs, ext string) bool {
+	if PreferredAudioCodec(ext) == "" {
+		return true
+	}
+	codec := strings.ToLower(codecs)
+	for _, c := range []string{"opus", "vorbis", "flac"} {
+		if strings.HasPrefix(codec, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// runFFmpeg runs ffmpeg with args. A failure saves ffmpeg's full stderr to
+// logFile and puts its last lines in the error. When ctx is done ffmpeg is
+// interrupted, as if by Ctrl+C, and the error wraps ctx.Err().
+func runFFmpeg(ctx context.Context, step string, args []string, logFile string) error {
+	cmd := execCommand(ctx, "ffmpeg", args...)
+	// An interrupt lets ffmpeg stop cleanly; a kill follows if it doesn't
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cancelGrace
+
+	// Keep a copy of stderr so the real cause survives past the terminal scrollback
+	var stderr bytes.Buffer
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg merge failed: %w", err)
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg %s cancelled: %w", step, ctx.Err())
+		}
+		if werr := os.WriteFile(logFile, stderr.Bytes(), 0644); werr != nil {
+			return fmt.Errorf("ffmpeg %s failed: %w\n%s", step, err, tailLines(stderr.String(), logTailLines))
+		}
+		return fmt.Errorf("ffmpeg %s failed: %w (full log: %s)\n%s", step, err, logFile, tailLines(stderr.String(), logTailLines))
 	}
-
+	// A log left by an earlier failed attempt, e.g. the stream copy before a
+	// remux, no longer describes the output
+	_ = os.Remove(logFile)
 	return nil
 }
+
+// metadataArgs turns metadata into -metadata flags, sorted for stable command lines.
+func metadataArgs(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, "-metadata", k+"="+metadata[k])
+	}
+	return args
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
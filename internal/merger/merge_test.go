@@ -1,9 +1,15 @@
 package merger
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // We want to mock exec.Command, but in Go that's tricky without an interface or variable.
@@ -14,44 +20,254 @@ import (
 func TestMergeAudioVideo(t *testing.T) {
 	// Mock successful ffmpeg call
 	// We replace execCommand with a helper that just exits success
-	execCommand = func(name string, arg ...string) *exec.Cmd {
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
 		cs := []string{"-test.run=TestHelperProcess", "--", name}
 		cs = append(cs, arg...)
-		cmd := exec.Command(os.Args[0], cs...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
 		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
 		return cmd
 	}
-	defer func() { execCommand = exec.Command }()
+	defer func() { execCommand = exec.CommandContext }()
 
-	err := MergeAudioVideo("video.mp4", "audio.mp4", "output.mp4")
+	output := filepath.Join(t.TempDir(), "output.mp4")
+	err := MergeAudioVideo(context.Background(), "video.mp4", "audio.mp4", output, nil)
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("expected the finished merge at %s: %v", output, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(output), "output.part.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected the .part file to be renamed, got %v", err)
+	}
+}
+
+func TestRemuxAudioVideo(t *testing.T) {
+	var gotArgs []string
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		gotArgs = arg
+		cs := []string{"-test.run=TestHelperProcess", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	output := filepath.Join(t.TempDir(), "output.mp4")
+	// Left by the failed stream copy before it
+	if err := os.WriteFile(output+".ffmpeg.log", []byte("error"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemuxAudioVideo(context.Background(), "video.mp4", "audio.mp4", output, map[string]string{"title": "T"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	args := strings.Join(gotArgs, " ")
+	if !strings.HasPrefix(args, "-y -fflags +genpts+igndts -i video.mp4 -fflags +genpts+igndts -i audio.mp4 -c:v copy -c:a copy") {
+		t.Errorf("expected regenerated timestamps on both inputs, got %v", gotArgs)
+	}
+	if !strings.Contains(args, "-avoid_negative_ts make_zero") || !strings.HasSuffix(args, "-metadata title=T "+filepath.Join(filepath.Dir(output), "output.part.mp4")) {
+		t.Errorf("unexpected output options %v", gotArgs)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("expected the finished remux at %s: %v", output, err)
+	}
+	if _, err := os.Stat(output + ".ffmpeg.log"); !os.IsNotExist(err) {
+		t.Errorf("expected the stale ffmpeg log to be removed, got %v", err)
+	}
+}
+
+func TestMergeAudioVideo_Cancel(t *testing.T) {
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestHelperProcessHang", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mp4")
+	start := time.Now()
+	err := MergeAudioVideo(ctx, "video.mp4", "audio.mp4", output, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "merge cancelled") {
+		t.Errorf("expected a cancelled merge, got %v", err)
+	}
+	if time.Since(start) > cancelGrace {
+		t.Errorf("expected ffmpeg to be stopped promptly, took %v", time.Since(start))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected no output or .part file, got %v", entries)
+	}
 }
 
 func TestMergeAudioVideo_Fail(t *testing.T) {
 	// Mock failed ffmpeg call
-	execCommand = func(name string, arg ...string) *exec.Cmd {
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
 		cs := []string{"-test.run=TestHelperProcessFail", "--", name}
 		cs = append(cs, arg...)
-		cmd := exec.Command(os.Args[0], cs...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
 		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
 		return cmd
 	}
-	defer func() { execCommand = exec.Command }()
+	defer func() { execCommand = exec.CommandContext }()
 
-	err := MergeAudioVideo("video.mp4", "audio.mp4", "output.mp4")
+	err := MergeAudioVideo(context.Background(), "video.mp4", "audio.mp4", filepath.Join(t.TempDir(), "output.mp4"), nil)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 	}
 }
 
+func TestMergeAudioVideo_FailLog(t *testing.T) {
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestHelperProcessFailLog", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	output := filepath.Join(t.TempDir(), "output.mp4")
+	err := MergeAudioVideo(context.Background(), "video.mp4", "audio.mp4", output, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "line 9") || strings.Contains(err.Error(), "line 4") {
+		t.Errorf("expected error to contain the tail of the log, got %v", err)
+	}
+
+	data, rerr := os.ReadFile(output + ".ffmpeg.log")
+	if rerr != nil {
+		t.Fatalf("expected ffmpeg log to be written: %v", rerr)
+	}
+	if !strings.Contains(string(data), "line 0") {
+		t.Errorf("expected full log, got %q", string(data))
+	}
+}
+
+func TestTranscodeAudio(t *testing.T) {
+	var gotArgs []string
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		gotArgs = arg
+		cs := []string{"-test.run=TestHelperProcess", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	dir := t.TempDir()
+	out, err := TranscodeAudio(context.Background(), filepath.Join(dir, "audio.mp4"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if out != filepath.Join(dir, "audio.aac.m4a") {
+		t.Errorf("unexpected output file %q", out)
+	}
+	if !strings.Contains(strings.Join(gotArgs, " "), "-c:a aac") {
+		t.Errorf("expected an AAC encode, got %v", gotArgs)
+	}
+}
+
+func TestTranscodeAudio_Fail(t *testing.T) {
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestHelperProcessFail", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	_, err := TranscodeAudio(context.Background(), filepath.Join(t.TempDir(), "audio.mp4"))
+	if err == nil || !strings.Contains(err.Error(), "audio conversion failed") {
+		t.Errorf("expected conversion error, got %v", err)
+	}
+}
+
+func TestTonemapSDR(t *testing.T) {
+	var gotArgs []string
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		gotArgs = arg
+		cs := []string{"-test.run=TestHelperProcess", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+		return cmd
+	}
+	defer func() { execCommand = exec.CommandContext }()
+
+	dir := t.TempDir()
+	out, err := TonemapSDR(context.Background(), filepath.Join(dir, "video.mp4"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if out != filepath.Join(dir, "video.sdr.mp4") {
+		t.Errorf("unexpected output file %q", out)
+	}
+	if args := strings.Join(gotArgs, " "); !strings.Contains(args, "tonemap=") || !strings.Contains(args, "-c:v libx264") {
+		t.Errorf("expected a tonemapping H.264 encode, got %v", gotArgs)
+	}
+}
+
+func TestAudioCompatible(t *testing.T) {
+	tests := []struct {
+		codecs, ext string
+		want        bool
+	}{
+		{"mp4a.40.2", ".mp4", true},
+		{"opus", ".mp4", false},
+		{"Opus", ".M4V", false},
+		{"flac", ".mov", false},
+		{"ec-3", ".mp4", true},
+		{"opus", ".mkv", true},
+	}
+	for _, tt := range tests {
+		if got := AudioCompatible(tt.codecs, tt.ext); got != tt.want {
+			t.Errorf("AudioCompatible(%q, %q) = %v, want %v", tt.codecs, tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataArgs(t *testing.T) {
+	got := metadataArgs(map[string]string{"title": "T", "comment": "C"})
+	expected := []string{"-metadata", "comment=C", "-metadata", "title=T"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("metadataArgs() = %v, want %v", got, expected)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	got := tailLines("a\nb\nc\n", 2)
+	if got != "b\nc" {
+		t.Errorf("tailLines() = %q, want %q", got, "b\nc")
+	}
+}
+
 // TestHelperProcess isn't a real test. It's used as a helper process to mock exec.Command
 func TestHelperProcess(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
 	}
-	// Verify arguments if needed, for now just exit 0
+	// Like ffmpeg, write the output file, the last argument
+	if err := os.WriteFile(os.Args[len(os.Args)-1], []byte("ffmpeg output"), 0644); err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+// TestHelperProcessHang stands in for an ffmpeg that runs until it is interrupted.
+func TestHelperProcessHang(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	_ = os.WriteFile(os.Args[len(os.Args)-1], []byte("partial"), 0644)
+	time.Sleep(time.Minute)
 	os.Exit(0)
 }
 
@@ -61,3 +277,13 @@ func TestHelperProcessFail(t *testing.T) {
 	}
 	os.Exit(1)
 }
+
+func TestHelperProcessFailLog(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		_, _ = fmt.Fprintf(os.Stderr, "line %d\n", i)
+	}
+	os.Exit(1)
+}
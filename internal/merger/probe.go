@@ -0,0 +1,224 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+}
+
+type probeStream struct {
+	CodecType string `json:"codec_type"`
+	StartTime string `json:"start_time"`
+	Duration  string `json:"duration"`
+}
+
+// CheckSync compares the first and last presentation timestamps of the audio
+// and video streams in file and returns the larger of the two differences in seconds.
+func CheckSync(file string) (float64, error) {
+	cmd := execCommand(context.Background(), "ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,start_time,duration",
+		"-of", "json",
+		file,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var video, audio *probeStream
+	for i := range probe.Streams {
+		s := &probe.Streams[i]
+		switch {
+		case s.CodecType == "video" && video == nil:
+			video = s
+		case s.CodecType == "audio" && audio == nil:
+			audio = s
+		}
+	}
+	if video == nil || audio == nil {
+		return 0, fmt.Errorf("expected one audio and one video stream in %s", file)
+	}
+
+	vStart, vEnd, err := streamBounds(video)
+	if err != nil {
+		return 0, err
+	}
+	aStart, aEnd, err := streamBounds(audio)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Max(math.Abs(vStart-aStart), math.Abs(vEnd-aEnd)), nil
+}
+
+func streamBounds(s *probeStream) (float64, float64, error) {
+	start, err := strconv.ParseFloat(s.StartTime, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s start_time %q", s.CodecType, s.StartTime)
+	}
+	duration, err := strconv.ParseFloat(s.Duration, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s duration %q", s.CodecType, s.Duration)
+	}
+	return start, start + duration, nil
+}
+
+// FileInfo describes a media file as reported by ffprobe.
+type FileInfo struct {
+	Path     string            `json:"path"`
+	Format   string            `json:"format"`
+	Duration float64           `json:"duration"`
+	Size     int64             `json:"size"`
+	BitRate  int64             `json:"bit_rate"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Streams  []StreamInfo      `json:"streams"`
+	Chapters []Chapter         `json:"chapters,omitempty"`
+}
+
+// StreamInfo is one stream of a FileInfo. Fields that don't apply to the
+// stream's kind are left zero.
+type StreamInfo struct {
+	Index      int     `json:"index"`
+	Kind       string  `json:"kind"`
+	Codec      string  `json:"codec"`
+	Profile    string  `json:"profile,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	Lang       string  `json:"lang,omitempty"`
+	BitRate    int64   `json:"bit_rate,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+}
+
+// Chapter is a chapter marker of a FileInfo, in seconds.
+type Chapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title,omitempty"`
+}
+
+type inspectOutput struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Size       string            `json:"size"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index        int               `json:"index"`
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Profile      string            `json:"profile"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		Channels     int               `json:"channels"`
+		SampleRate   string            `json:"sample_rate"`
+		BitRate      string            `json:"bit_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+	} `json:"streams"`
+	Chapters []struct {
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+}
+
+// Inspect runs ffprobe on file and returns its container, stream and chapter details.
+func Inspect(file string) (*FileInfo, error) {
+	cmd := execCommand(context.Background(), "ffprobe",
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		"-of", "json",
+		file,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe inspectOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	// ffprobe leaves out fields it can't determine and prints the rest as
+	// strings, so anything unparsable is simply reported as zero
+	info := &FileInfo{
+		Path:     file,
+		Format:   probe.Format.FormatName,
+		Duration: parseFloat(probe.Format.Duration),
+		Size:     parseInt(probe.Format.Size),
+		BitRate:  parseInt(probe.Format.BitRate),
+		Tags:     probe.Format.Tags,
+		Streams:  make([]StreamInfo, 0, len(probe.Streams)),
+	}
+	for _, s := range probe.Streams {
+		info.Streams = append(info.Streams, StreamInfo{
+			Index:      s.Index,
+			Kind:       s.CodecType,
+			Codec:      s.CodecName,
+			Profile:    s.Profile,
+			Width:      s.Width,
+			Height:     s.Height,
+			FPS:        parseRate(s.AvgFrameRate),
+			Channels:   s.Channels,
+			SampleRate: int(parseInt(s.SampleRate)),
+			Lang:       s.Tags["language"],
+			BitRate:    parseInt(s.BitRate),
+			Duration:   parseFloat(s.Duration),
+		})
+	}
+	for _, c := range probe.Chapters {
+		info.Chapters = append(info.Chapters, Chapter{
+			Start: parseFloat(c.StartTime),
+			End:   parseFloat(c.EndTime),
+			Title: c.Tags["title"],
+		})
+	}
+	return info, nil
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// parseRate handles ffprobe's fractional rates, e.g. "30000/1001". "0/0" means unknown.
+func parseRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
@@ -0,0 +1,102 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func mockProbe(t *testing.T, output string) {
+	t.Helper()
+	execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestHelperProcessProbe", "--", name}
+		cs = append(cs, arg...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1", "PROBE_OUTPUT=" + output}
+		return cmd
+	}
+	t.Cleanup(func() { execCommand = exec.CommandContext })
+}
+
+func TestCheckSync(t *testing.T) {
+	mockProbe(t, `{"streams": [
+		{"codec_type": "video", "start_time": "0.000000", "duration": "60.000000"},
+		{"codec_type": "audio", "start_time": "0.100000", "duration": "59.500000"}
+	]}`)
+
+	drift, err := CheckSync("output.mp4")
+	if err != nil {
+		t.Fatalf("CheckSync failed: %v", err)
+	}
+	// start differs by 0.1s, end by 0.4s
+	if math.Abs(drift-0.4) > 1e-9 {
+		t.Errorf("expected drift 0.4, got %f", drift)
+	}
+}
+
+func TestCheckSync_MissingStream(t *testing.T) {
+	mockProbe(t, `{"streams": [{"codec_type": "video", "start_time": "0", "duration": "60"}]}`)
+
+	if _, err := CheckSync("output.mp4"); err == nil {
+		t.Error("expected error when audio stream is missing, got nil")
+	}
+}
+
+func TestCheckSync_BadOutput(t *testing.T) {
+	mockProbe(t, `not json`)
+
+	if _, err := CheckSync("output.mp4"); err == nil {
+		t.Error("expected error on malformed ffprobe output, got nil")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	mockProbe(t, `{
+		"streams": [
+			{"index": 0, "codec_type": "video", "codec_name": "h264", "profile": "High", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001", "bit_rate": "4000000", "duration": "60.000000"},
+			{"index": 1, "codec_type": "audio", "codec_name": "aac", "avg_frame_rate": "0/0", "channels": 2, "sample_rate": "48000", "tags": {"language": "eng"}}
+		],
+		"chapters": [{"start_time": "0.000000", "end_time": "30.000000", "tags": {"title": "Intro"}}],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "60.010000", "size": "31000000", "bit_rate": "4132000", "tags": {"comment": "https://example.com/iframe"}}
+	}`)
+
+	info, err := Inspect("output.mp4")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if info.Duration != 60.01 || info.Size != 31000000 || info.Tags["comment"] != "https://example.com/iframe" {
+		t.Errorf("unexpected format details %+v", info)
+	}
+	if len(info.Streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(info.Streams))
+	}
+	v, a := info.Streams[0], info.Streams[1]
+	if v.Kind != "video" || v.Codec != "h264" || v.Height != 1080 || math.Abs(v.FPS-29.97) > 0.01 {
+		t.Errorf("unexpected video stream %+v", v)
+	}
+	if a.Kind != "audio" || a.FPS != 0 || a.Channels != 2 || a.SampleRate != 48000 || a.Lang != "eng" {
+		t.Errorf("unexpected audio stream %+v", a)
+	}
+	if len(info.Chapters) != 1 || info.Chapters[0].Title != "Intro" || info.Chapters[0].End != 30 {
+		t.Errorf("unexpected chapters %+v", info.Chapters)
+	}
+}
+
+func TestInspect_BadOutput(t *testing.T) {
+	mockProbe(t, `not json`)
+
+	if _, err := Inspect("output.mp4"); err == nil {
+		t.Error("expected error on malformed ffprobe output, got nil")
+	}
+}
+
+func TestHelperProcessProbe(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("PROBE_OUTPUT"))
+	os.Exit(0)
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ManifestCache dedupes manifest fetches by URL so jobs for the same video
+// (e.g. different resolutions in one batch) share a single parsed MPD.
+// Concurrent Get calls for a URL wait for the same fetch. The returned *MPD
+// is shared and must be treated as read-only. Failed fetches are not cached.
+type ManifestCache struct {
+	fetch func(ctx context.Context, url string) (*MPD, error)
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	done chan struct{}
+	mpd  *MPD
+	err  error
+}
+
+// NewManifestCache returns a cache that loads manifests with fetch, typically ParseManifest.
+func NewManifestCache(fetch func(ctx context.Context, url string) (*MPD, error)) *ManifestCache {
+	return &ManifestCache{fetch: fetch, entries: make(map[string]*cacheEntry)}
+}
+
+// Get returns the manifest for url, fetching it at most once at a time. The
+// fetch runs with the ctx of the caller that starts it; callers waiting on it
+// stop waiting when their own ctx is done.
+func (c *ManifestCache) Get(ctx context.Context, url string) (*MPD, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[url]; ok {
+		c.mu.Unlock()
+		select {
+		case <-e.done:
+			return e.mpd, e.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	e := &cacheEntry{done: make(chan struct{})}
+	c.entries[url] = e
+	c.mu.Unlock()
+
+	// Deferred so a panicking fetch still releases the callers waiting on it
+	fetched := false
+	defer func() {
+		if !fetched {
+			e.err = errors.New("manifest fetch panicked")
+		}
+		if e.err != nil {
+			// Let the next caller retry instead of replaying the error forever
+			c.mu.Lock()
+			delete(c.entries, url)
+			c.mu.Unlock()
+		}
+		close(e.done)
+	}()
+	e.mpd, e.err = c.fetch(ctx, url)
+	fetched = true
+
+	return e.mpd, e.err
+}
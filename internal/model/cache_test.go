@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManifestCache_Dedupe(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewManifestCache(func(ctx context.Context, url string) (*MPD, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond) // Keep the fetch in flight while others arrive
+		return &MPD{MediaPresentationDuration: url}, nil
+	})
+
+	var wg sync.WaitGroup
+	results := make([]*MPD, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mpd, err := cache.Get(context.Background(), "https://example.com/a.mpd")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results[i] = mpd
+		}(i)
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 fetch, got %d", calls.Load())
+	}
+	for _, mpd := range results {
+		if mpd != results[0] {
+			t.Fatal("expected all callers to share the same MPD")
+		}
+	}
+
+	if _, err := cache.Get(context.Background(), "https://example.com/b.mpd"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected a separate fetch for another URL, got %d fetches", calls.Load())
+	}
+}
+
+func TestManifestCache_Panic(t *testing.T) {
+	calls := 0
+	cache := NewManifestCache(func(ctx context.Context, url string) (*MPD, error) {
+		if calls++; calls == 1 {
+			panic("boom")
+		}
+		return &MPD{}, nil
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to reach the caller")
+			}
+		}()
+		_, _ = cache.Get(context.Background(), "https://example.com/a.mpd")
+	}()
+	// A panicking fetch isn't cached, so the next Get doesn't wait on it forever
+	if _, err := cache.Get(context.Background(), "https://example.com/a.mpd"); err != nil {
+		t.Errorf("expected retry to succeed, got %v", err)
+	}
+}
+
+func TestManifestCache_ErrorNotCached(t *testing.T) {
+	calls := 0
+	cache := NewManifestCache(func(ctx context.Context, url string) (*MPD, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("temporary failure")
+		}
+		return &MPD{}, nil
+	})
+
+	if _, err := cache.Get(context.Background(), "https://example.com/a.mpd"); err == nil {
+		t.Fatal("expected first fetch to fail")
+	}
+	if _, err := cache.Get(context.Background(), "https://example.com/a.mpd"); err != nil {
+		t.Errorf("expected retry to succeed, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 fetches, got %d", calls)
+	}
+}
+
+func TestManifestCache_WaiterCancelled(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	cache := NewManifestCache(func(ctx context.Context, url string) (*MPD, error) {
+		close(started)
+		<-release
+		return &MPD{}, nil
+	})
+	defer close(release)
+	go func() { _, _ = cache.Get(context.Background(), "https://example.com/a.mpd") }()
+	<-started
+
+	// A caller waiting on another's fetch still stops with its own ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.Get(ctx, "https://example.com/a.mpd"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
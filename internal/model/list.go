@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RepresentationInfo is a flattened view of a Representation, with the
+// attributes it inherits from its AdaptationSet filled in.
+type RepresentationInfo struct {
+	Kind          string  `json:"kind"` // "video", "audio" or "text"
+	ID            string  `json:"id"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FPS           float64 `json:"fps,omitempty"`
+	Codec         string  `json:"codec,omitempty"`
+	Lang          string  `json:"lang,omitempty"`
+	Bandwidth     int     `json:"bandwidth"`
+	EstimatedSize int64   `json:"estimated_size,omitempty"` // Bytes, from bandwidth and duration
+
+	// SegmentTemplate lets a saved listing drive a download without the manifest.
+	SegmentTemplate *SegmentTemplate `json:"segment_template,omitempty"`
+	// Descriptors and DRM signalling, including the adaptation set's, so a
+	// saved listing keeps the HDR and DRM checks working.
+	Properties        []Descriptor        `json:"supplemental_properties,omitempty"`
+	Essential         []Descriptor        `json:"essential_properties,omitempty"`
+	ContentProtection []ContentProtection `json:"content_protection,omitempty"`
+
+	Representation *Representation `json:"-"`
+}
+
+// ListRepresentations returns every representation in the manifest in
+// document order.
+func (mpd *MPD) ListRepresentations() []RepresentationInfo {
+	duration := mpd.DurationSeconds()
+
+	var list []RepresentationInfo
+	for i := range mpd.Period.AdaptationSets {
+		as := &mpd.Period.AdaptationSets[i]
+		for j := range as.Representations {
+			rep := &as.Representations[j]
+
+			frameRate := rep.FrameRate
+			if frameRate == "" {
+				frameRate = as.FrameRate
+			}
+
+			list = append(list, RepresentationInfo{
+				Kind:            kindOf(as.MimeType),
+				ID:              rep.ID,
+				Width:           rep.Width,
+				Height:          rep.Height,
+				FPS:             parseFrameRate(frameRate),
+				Codec:           rep.Codecs,
+				Lang:            as.Lang,
+				Bandwidth:       rep.Bandwidth,
+				EstimatedSize:   int64(float64(rep.Bandwidth) / 8 * duration),
+				SegmentTemplate: &rep.SegmentTemplate,
+				Representation:  rep,
+
+				Properties:        slices.Concat(rep.Properties, as.Properties),
+				Essential:         slices.Concat(rep.Essential, as.Essential),
+				ContentProtection: slices.Concat(rep.ContentProtection, as.ContentProtection),
+			})
+		}
+	}
+	return list
+}
+
+// AdaptationSetsFromList rebuilds adaptation sets from a listing saved by
+// ListRepresentations, grouping representations by kind and language in the
+// order they appear. Only video and audio are kept, and every entry needs its
+// segment template.
+func AdaptationSetsFromList(list []RepresentationInfo) ([]AdaptationSet, error) {
+	var sets []AdaptationSet
+	index := make(map[string]int)
+	for _, info := range list {
+		if info.Kind != "video" && info.Kind != "audio" {
+			continue
+		}
+		if info.SegmentTemplate == nil {
+			return nil, fmt.Errorf("representation %s has no segment template", info.ID)
+		}
+
+		key := info.Kind + "/" + info.Lang
+		i, ok := index[key]
+		if !ok {
+			i = len(sets)
+			index[key] = i
+			sets = append(sets, AdaptationSet{MimeType: info.Kind + "/mp4", Lang: info.Lang})
+		}
+
+		rep := Representation{
+			ID:                info.ID,
+			Bandwidth:         info.Bandwidth,
+			Codecs:            info.Codec,
+			Width:             info.Width,
+			Height:            info.Height,
+			SegmentTemplate:   *info.SegmentTemplate,
+			Properties:        info.Properties,
+			Essential:         info.Essential,
+			ContentProtection: info.ContentProtection,
+		}
+		if info.FPS > 0 {
+			rep.FrameRate = strconv.FormatFloat(info.FPS, 'f', -1, 64)
+		}
+		sets[i].Representations = append(sets[i].Representations, rep)
+	}
+	return sets, nil
+}
+
+// kindOf maps a mimeType like "video/mp4" to "video".
+func kindOf(mimeType string) string {
+	kind, _, _ := strings.Cut(mimeType, "/")
+	if kind == "application" {
+		return "text" // e.g. application/ttml+xml subtitles
+	}
+	return kind
+}
+
+// parseFrameRate handles both plain ("30") and fractional ("30000/1001") frame rates.
+func parseFrameRate(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	num, den, found := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"testing"
+)
+
+func TestListRepresentations(t *testing.T) {
+	xmlData := `
+<MPD mediaPresentationDuration="PT10S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4" frameRate="30">
+      <Representation id="720p" bandwidth="1500000" codecs="avc1.64001f" width="1280" height="720" />
+      <Representation id="1080p" bandwidth="4000000" codecs="avc1.640028" width="1920" height="1080" frameRate="30000/1001" />
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" lang="en">
+      <Representation id="audio" bandwidth="128000" codecs="mp4a.40.2" />
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	var mpd MPD
+	if err := xml.Unmarshal([]byte(xmlData), &mpd); err != nil {
+		t.Fatalf("failed to unmarshal XML: %v", err)
+	}
+
+	list := mpd.ListRepresentations()
+	if len(list) != 3 {
+		t.Fatalf("expected 3 representations, got %d", len(list))
+	}
+
+	if list[0].Kind != "video" || list[0].FPS != 30 || list[0].EstimatedSize != 1875000 {
+		t.Errorf("unexpected 720p entry %+v", list[0])
+	}
+	if math.Abs(list[1].FPS-29.97) > 0.01 || list[1].Codec != "avc1.640028" {
+		t.Errorf("unexpected 1080p entry %+v", list[1])
+	}
+	if list[2].Kind != "audio" || list[2].Lang != "en" || list[2].Height != 0 {
+		t.Errorf("unexpected audio entry %+v", list[2])
+	}
+	if list[2].Representation != &mpd.Period.AdaptationSets[1].Representations[0] {
+		t.Error("expected entry to point at the manifest representation")
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"", 0},
+		{"25", 25},
+		{"60/2", 30},
+		{"30/0", 0},
+		{"abc", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := parseFrameRate(tt.input); got != tt.expected {
+				t.Errorf("parseFrameRate(%q) = %f, want %f", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAdaptationSetsFromList(t *testing.T) {
+	tmpl := SegmentTemplate{Media: "seg-$Number$.m4s", Initialization: "init.mp4", StartNumber: 1, Duration: 4, Timescale: 1}
+	mpd := &MPD{
+		Period: Period{
+			AdaptationSets: []AdaptationSet{
+				{MimeType: "video/mp4", FrameRate: "30", Representations: []Representation{
+					{ID: "720p", Height: 720, Bandwidth: 2000000, Codecs: "avc1", SegmentTemplate: tmpl},
+					{ID: "1080p", Height: 1080, Bandwidth: 4000000, Codecs: "avc1", SegmentTemplate: tmpl},
+				}},
+				{MimeType: "audio/mp4", Lang: "en", Representations: []Representation{{ID: "en", Bandwidth: 128000, SegmentTemplate: tmpl}}},
+				{MimeType: "audio/mp4", Lang: "de", Representations: []Representation{{ID: "de", Bandwidth: 96000, SegmentTemplate: tmpl}}},
+				{MimeType: "text/vtt", Representations: []Representation{{ID: "subs"}}},
+			},
+		},
+	}
+
+	sets, err := AdaptationSetsFromList(mpd.ListRepresentations())
+	if err != nil {
+		t.Fatalf("AdaptationSetsFromList failed: %v", err)
+	}
+	if len(sets) != 3 {
+		t.Fatalf("expected video, en and de sets, got %+v", sets)
+	}
+	if sets[0].MimeType != "video/mp4" || len(sets[0].Representations) != 2 || sets[0].Representations[1].FrameRate != "30" {
+		t.Errorf("unexpected video set %+v", sets[0])
+	}
+	if sets[2].Lang != "de" || sets[2].Representations[0].SegmentTemplate != tmpl {
+		t.Errorf("unexpected de set %+v", sets[2])
+	}
+
+	rebuilt := &MPD{Period: Period{AdaptationSets: sets}}
+	if rep, err := rebuilt.Select("audio", SelectionPolicy{Language: "de"}); err != nil || rep.ID != "de" {
+		t.Errorf("expected rebuilt manifest to select de audio, got %v, %v", rep, err)
+	}
+}
+
+func TestAdaptationSetsFromList_Descriptors(t *testing.T) {
+	tmpl := SegmentTemplate{Media: "seg-$Number$.m4s", Duration: 4, Timescale: 1}
+	mpd := &MPD{
+		Period: Period{
+			AdaptationSets: []AdaptationSet{
+				{
+					MimeType:   "video/mp4",
+					Properties: []Descriptor{{SchemeIDURI: cicpTransfer, Value: "16"}},
+					Representations: []Representation{
+						{ID: "1080p", Height: 1080, SegmentTemplate: tmpl, ContentProtection: []ContentProtection{{SchemeIDURI: "urn:mpeg:dash:mp4protection:2011"}}},
+					},
+				},
+			},
+		},
+	}
+
+	// Through JSON, as --dump-json and --load-info do
+	data, err := json.Marshal(mpd.ListRepresentations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []RepresentationInfo
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := AdaptationSetsFromList(list)
+	if err != nil {
+		t.Fatalf("AdaptationSetsFromList failed: %v", err)
+	}
+
+	rebuilt := &MPD{Period: Period{AdaptationSets: sets}}
+	if f := rebuilt.HDR(&rebuilt.Period.AdaptationSets[0].Representations[0]); f != "PQ" {
+		t.Errorf("expected the rebuilt stream to be PQ, got %q", f)
+	}
+	if !rebuilt.Protected() {
+		t.Error("expected the rebuilt manifest to be protected")
+	}
+}
+
+func TestAdaptationSetsFromList_NoTemplate(t *testing.T) {
+	if _, err := AdaptationSetsFromList([]RepresentationInfo{{Kind: "video", ID: "old"}}); err == nil {
+		t.Error("expected error for an entry without a segment template, got nil")
+	}
+}
@@ -1,14 +1,19 @@
 package model
 
 import (
+	"cfs-dl/internal/httpclient"
+	"context"
 	"encoding/xml"
 	"fmt"
-	"io"
-	"net/http"
+	"strings"
+	"time"
 )
 
 type MPD struct {
 	XMLName                   xml.Name            `xml:"MPD"`
+	Type                      string              `xml:"type,attr"` // "static" (VOD, the default) or "dynamic" (live)
+	AvailabilityStartTime     string              `xml:"availabilityStartTime,attr"`
+	PublishTime               string              `xml:"publishTime,attr"`
 	MediaPresentationDuration string              `xml:"mediaPresentationDuration,attr"`
 	MinBufferTime             string              `xml:"minBufferTime,attr"`
 	ProgramInformation        *ProgramInformation `xml:"ProgramInformation"`
@@ -24,42 +29,66 @@ type Period struct {
 }
 
 type AdaptationSet struct {
-	ID              int              `xml:"id,attr"`
-	MimeType        string           `xml:"mimeType,attr"`
-	Representations []Representation `xml:"Representation"`
+	ID                int                 `xml:"id,attr"`
+	MimeType          string              `xml:"mimeType,attr"`
+	Lang              string              `xml:"lang,attr"`
+	FrameRate         string              `xml:"frameRate,attr"`
+	ContentProtection []ContentProtection `xml:"ContentProtection"`
+	Properties        []Descriptor        `xml:"SupplementalProperty"`
+	Essential         []Descriptor        `xml:"EssentialProperty"`
+	Representations   []Representation    `xml:"Representation"`
 }
 
 type Representation struct {
-	ID              string          `xml:"id,attr"`
-	Bandwidth       int             `xml:"bandwidth,attr"`
-	Codecs          string          `xml:"codecs,attr"`
-	Width           int             `xml:"width,attr"`
-	Height          int             `xml:"height,attr"`
-	SegmentTemplate SegmentTemplate `xml:"SegmentTemplate"`
+	ID                string              `xml:"id,attr"`
+	Bandwidth         int                 `xml:"bandwidth,attr"`
+	Codecs            string              `xml:"codecs,attr"`
+	Width             int                 `xml:"width,attr"`
+	Height            int                 `xml:"height,attr"`
+	FrameRate         string              `xml:"frameRate,attr"`
+	ContentProtection []ContentProtection `xml:"ContentProtection"`
+	Properties        []Descriptor        `xml:"SupplementalProperty"`
+	Essential         []Descriptor        `xml:"EssentialProperty"`
+	SegmentTemplate   SegmentTemplate     `xml:"SegmentTemplate"`
+}
+
+// ContentProtection marks DRM-encrypted content, e.g. Widevine or FairPlay.
+type ContentProtection struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr" json:"scheme_id_uri"`
+}
+
+// Descriptor is a SupplementalProperty or EssentialProperty, e.g. the CICP
+// color signalling of an HDR stream.
+type Descriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr" json:"scheme_id_uri"`
+	Value       string `xml:"value,attr" json:"value"`
 }
 
 type SegmentTemplate struct {
-	Duration       int    `xml:"duration,attr"`
-	Initialization string `xml:"initialization,attr"`
-	Media          string `xml:"media,attr"`
-	StartNumber    int    `xml:"startNumber,attr"`
-	Timescale      int    `xml:"timescale,attr"`
+	Duration       int    `xml:"duration,attr" json:"duration"`
+	Initialization string `xml:"initialization,attr" json:"initialization"`
+	Media          string `xml:"media,attr" json:"media"`
+	StartNumber    int    `xml:"startNumber,attr" json:"start_number"`
+	Timescale      int    `xml:"timescale,attr" json:"timescale"`
 }
 
 func ParseManifest(url string) (*MPD, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch manifest, status: %s", resp.Status)
-	}
+	return ParseManifestContext(context.Background(), url)
+}
 
-	data, err := io.ReadAll(resp.Body)
+// manifestRetry bounds each manifest request so a blackholed route fails
+// instead of hanging before any progress output.
+var manifestRetry = func() httpclient.RetryPolicy {
+	p := httpclient.DefaultRetry
+	p.Timeout = 30 * time.Second
+	return p
+}()
+
+// ParseManifestContext is like ParseManifest but stops retrying when ctx is done.
+func ParseManifestContext(ctx context.Context, url string) (*MPD, error) {
+	data, err := httpclient.Fetch(ctx, url, manifestRetry)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read manifest body: %w", err)
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
 	}
 
 	var mpd MPD
@@ -70,44 +99,130 @@ func ParseManifest(url string) (*MPD, error) {
 	return &mpd, nil
 }
 
-func (mpd *MPD) SelectVideoRepresentation(targetHeight int) (*Representation, error) {
-	var bestRep *Representation
-	var minDiff = 10000 // Arbitrary large number
+// ParseDuration converts an ISO 8601 duration as used by MPD attributes
+// (e.g. "PT1H2M3.5S") to seconds.
+func ParseDuration(durationStr string) (float64, error) {
+	s := strings.TrimPrefix(durationStr, "PT")
+	var hours, minutes, seconds float64
+	if idx := strings.Index(s, "H"); idx != -1 {
+		_, _ = fmt.Sscanf(s[:idx], "%f", &hours)
+		s = s[idx+1:]
+	}
+	if idx := strings.Index(s, "M"); idx != -1 {
+		_, _ = fmt.Sscanf(s[:idx], "%f", &minutes)
+		s = s[idx+1:]
+	}
+	if idx := strings.Index(s, "S"); idx != -1 {
+		_, _ = fmt.Sscanf(s[:idx], "%f", &seconds)
+	}
+	return hours*3600 + minutes*60 + seconds, nil
+}
 
+// DurationSeconds returns the presentation duration in seconds, or 0 if the manifest doesn't say.
+func (mpd *MPD) DurationSeconds() float64 {
+	d, _ := ParseDuration(mpd.MediaPresentationDuration)
+	return d
+}
+
+// Protected reports whether any stream is DRM-encrypted, in which case the
+// downloaded segments can't be played without a license.
+func (mpd *MPD) Protected() bool {
 	for _, as := range mpd.Period.AdaptationSets {
-		if as.MimeType == "video/mp4" {
-			for i := range as.Representations {
-				rep := &as.Representations[i]
-				diff := abs(rep.Height - targetHeight)
-				if diff < minDiff {
-					minDiff = diff
-					bestRep = rep
-				}
+		if len(as.ContentProtection) > 0 {
+			return true
+		}
+		for _, rep := range as.Representations {
+			if len(rep.ContentProtection) > 0 {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	if bestRep == nil {
-		return nil, fmt.Errorf("no video representation found")
+// cicpTransfer is the DASH scheme for the transfer characteristics code
+// point of ISO/IEC 23091-2, the same numbers ffprobe reports as color_trc.
+const cicpTransfer = "urn:mpeg:mpegB:cicp:TransferCharacteristics"
+
+// HDR returns the HDR format of rep ("PQ", "HLG" or "Dolby Vision"), or ""
+// for SDR. It looks at the codecs string, which carries the transfer
+// characteristics for VP9 and AV1, and at the CICP properties of rep and its
+// adaptation set.
+func (mpd *MPD) HDR(rep *Representation) string {
+	codecs := strings.Split(strings.ToLower(rep.Codecs), ".")
+	switch codecs[0] {
+	case "dvh1", "dvhe", "dav1", "dvav", "dva1":
+		return "Dolby Vision"
+	case "vp09":
+		if len(codecs) > 6 {
+			return hdrTransfer(codecs[6])
+		}
+	case "av01":
+		if len(codecs) > 7 {
+			return hdrTransfer(codecs[7])
+		}
 	}
 
-	return bestRep, nil
-}
-
-func (mpd *MPD) SelectAudioRepresentation() (*Representation, error) {
+	props := append(append([]Descriptor{}, rep.Properties...), rep.Essential...)
 	for _, as := range mpd.Period.AdaptationSets {
-		if as.MimeType == "audio/mp4" {
-			if len(as.Representations) > 0 {
-				return &as.Representations[0], nil
+		for i := range as.Representations {
+			if &as.Representations[i] == rep {
+				props = append(append(props, as.Properties...), as.Essential...)
+			}
+		}
+	}
+	for _, d := range props {
+		if d.SchemeIDURI == cicpTransfer {
+			if f := hdrTransfer(d.Value); f != "" {
+				return f
 			}
 		}
 	}
-	return nil, fmt.Errorf("no audio representation found")
+	return ""
+}
+
+// hdrTransfer names the HDR transfer characteristics code point tc, or returns "" for SDR ones.
+func hdrTransfer(tc string) string {
+	switch strings.TrimLeft(tc, "0") {
+	case "16":
+		return "PQ"
+	case "18":
+		return "HLG"
+	}
+	return ""
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// PresentationType returns the MPD type, defaulting to "static" as the spec does.
+func (mpd *MPD) PresentationType() string {
+	if mpd.Type == "" {
+		return "static"
+	}
+	return mpd.Type
+}
+
+// AvailabilityStart returns availabilityStartTime, when the first segment of a
+// live presentation became available. ok is false if it is absent or invalid.
+func (mpd *MPD) AvailabilityStart() (t time.Time, ok bool) {
+	return parseDateTime(mpd.AvailabilityStartTime)
+}
+
+// Published returns publishTime, when this version of the manifest was
+// generated. ok is false if it is absent or invalid.
+func (mpd *MPD) Published() (t time.Time, ok bool) {
+	return parseDateTime(mpd.PublishTime)
+}
+
+// parseDateTime parses an xs:dateTime. A missing zone means UTC, which is
+// what packagers that omit it produce in practice.
+func parseDateTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05.999999999", s); err == nil {
+		return t, true
 	}
-	return x
+	return time.Time{}, false
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestParseManifest(t *testing.T) {
@@ -44,7 +45,7 @@ func TestParseManifest_Fail(t *testing.T) {
 	}
 }
 
-func TestSelectVideoRepresentation(t *testing.T) {
+func TestSelect_Video(t *testing.T) {
 	mpd := &MPD{
 		Period: Period{
 			AdaptationSets: []AdaptationSet{
@@ -83,7 +84,7 @@ func TestSelectVideoRepresentation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rep, err := mpd.SelectVideoRepresentation(tt.targetHeight)
+			rep, err := mpd.Select("video", SelectionPolicy{Height: tt.targetHeight})
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -97,7 +98,7 @@ func TestSelectVideoRepresentation(t *testing.T) {
 	}
 }
 
-func TestSelectAudioRepresentation(t *testing.T) {
+func TestSelect_Audio(t *testing.T) {
 	mpd := &MPD{
 		Period: Period{
 			AdaptationSets: []AdaptationSet{
@@ -118,11 +119,11 @@ func TestSelectAudioRepresentation(t *testing.T) {
 		},
 	}
 
-	rep, err := mpd.SelectAudioRepresentation()
+	rep, err := mpd.Select("audio", SelectionPolicy{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if rep.ID != "audio1" { // Current implementation picks the first one
+	if rep.ID != "audio1" { // Highest bandwidth wins without other preferences
 		t.Errorf("expected ID audio1, got %s", rep.ID)
 	}
 }
@@ -162,7 +163,7 @@ func TestParseManifest_BadURL(t *testing.T) {
 	}
 }
 
-func TestSelectAudioRepresentation_None(t *testing.T) {
+func TestSelect_AudioNone(t *testing.T) {
 	mpd := &MPD{
 		Period: Period{
 			AdaptationSets: []AdaptationSet{
@@ -177,7 +178,7 @@ func TestSelectAudioRepresentation_None(t *testing.T) {
 		},
 	}
 
-	_, err := mpd.SelectAudioRepresentation()
+	_, err := mpd.Select("audio", SelectionPolicy{})
 	if err == nil {
 		t.Error("expected error when no audio representation present, got nil")
 	}
@@ -195,7 +196,7 @@ func TestParseManifest_MalformedXML(t *testing.T) {
 	}
 }
 
-func TestSelectVideoRepresentation_None(t *testing.T) {
+func TestSelect_VideoNone(t *testing.T) {
 	mpd := &MPD{
 		Period: Period{
 			AdaptationSets: []AdaptationSet{
@@ -209,8 +210,128 @@ func TestSelectVideoRepresentation_None(t *testing.T) {
 		},
 	}
 
-	_, err := mpd.SelectVideoRepresentation(1080)
+	_, err := mpd.Select("video", SelectionPolicy{Height: 1080})
 	if err == nil {
 		t.Error("expected error when no video representation present, got nil")
 	}
 }
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"PT1M30S", 90.0},
+		{"PT45S", 45.0},
+		{"PT2H30M15S", 9015.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, _ := ParseDuration(tt.input)
+			if got != tt.expected {
+				t.Errorf("ParseDuration(%q) = %f, want %f", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseManifest_PresentationAttributes(t *testing.T) {
+	xmlData := `
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic" availabilityStartTime="2024-05-01T12:00:00Z" publishTime="2024-05-01T12:30:15.5">
+  <Period />
+</MPD>`
+	var mpd MPD
+	if err := xml.Unmarshal([]byte(xmlData), &mpd); err != nil {
+		t.Fatalf("failed to unmarshal XML: %v", err)
+	}
+
+	if got := mpd.PresentationType(); got != "dynamic" {
+		t.Errorf("expected type dynamic, got %q", got)
+	}
+	start, ok := mpd.AvailabilityStart()
+	if !ok || !start.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected availability start %v (ok=%v)", start, ok)
+	}
+	// No zone means UTC
+	published, ok := mpd.Published()
+	if !ok || !published.Equal(time.Date(2024, 5, 1, 12, 30, 15, 500000000, time.UTC)) {
+		t.Errorf("unexpected publish time %v (ok=%v)", published, ok)
+	}
+}
+
+func TestPresentationAttributes_Defaults(t *testing.T) {
+	mpd := &MPD{PublishTime: "yesterday"}
+	if got := mpd.PresentationType(); got != "static" {
+		t.Errorf("expected default type static, got %q", got)
+	}
+	if _, ok := mpd.AvailabilityStart(); ok {
+		t.Error("expected no availability start")
+	}
+	if _, ok := mpd.Published(); ok {
+		t.Error("expected invalid publish time to be rejected")
+	}
+}
+
+func TestProtected(t *testing.T) {
+	xmlData := `
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="720p" />
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="audio">
+        <ContentProtection schemeIdUri="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed" />
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	var mpd MPD
+	if err := xml.Unmarshal([]byte(xmlData), &mpd); err != nil {
+		t.Fatalf("failed to unmarshal XML: %v", err)
+	}
+	if !mpd.Protected() {
+		t.Error("expected a ContentProtection element to mark the manifest protected")
+	}
+
+	mpd.Period.AdaptationSets[1].Representations[0].ContentProtection = nil
+	if mpd.Protected() {
+		t.Error("expected a manifest without ContentProtection to be unprotected")
+	}
+}
+
+func TestHDR(t *testing.T) {
+	xmlData := `
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SupplementalProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="16" />
+      <Representation id="hevc" codecs="hvc1.2.4.L153.B0" />
+    </AdaptationSet>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="hlg" codecs="hvc1.2.4.L153.B0">
+        <EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="18" />
+      </Representation>
+      <Representation id="sdr" codecs="avc1.640028" />
+      <Representation id="vp9" codecs="vp09.02.10.10.01.09.16.09.00" />
+      <Representation id="av1" codecs="av01.0.04M.10.0.110.09.18.09.0" />
+      <Representation id="av1-sdr" codecs="av01.0.04M.08" />
+      <Representation id="dv" codecs="dvh1.05.06" />
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	var mpd MPD
+	if err := xml.Unmarshal([]byte(xmlData), &mpd); err != nil {
+		t.Fatalf("failed to unmarshal XML: %v", err)
+	}
+	want := map[string]string{"hevc": "PQ", "hlg": "HLG", "sdr": "", "vp9": "PQ", "av1": "HLG", "av1-sdr": "", "dv": "Dolby Vision"}
+	for _, as := range mpd.Period.AdaptationSets {
+		for i := range as.Representations {
+			rep := &as.Representations[i]
+			if got := mpd.HDR(rep); got != want[rep.ID] {
+				t.Errorf("HDR(%s) = %q, want %q", rep.ID, got, want[rep.ID])
+			}
+		}
+	}
+}
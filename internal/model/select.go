@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SelectionPolicy decides which representation of a kind to download.
+// Constraints rule candidates out; preferences rank the ones that are left.
+// Zero values mean "no constraint" or "no preference".
+type SelectionPolicy struct {
+	// Constraints
+	MaxHeight    int
+	MaxFPS       float64
+	MaxBandwidth int
+
+	// Preferences, in order of precedence
+	Language string // Exact match on the AdaptationSet lang
+	Codec    string // Prefix of the codecs attribute, e.g. "avc1" or "mp4a"
+	Height   int    // Closest height wins; without it the tallest wins
+}
+
+// Select returns the representation of kind ("video" or "audio") that best
+// satisfies p. Remaining ties go to the higher bandwidth, then to the first
+// representation in the manifest.
+func (mpd *MPD) Select(kind string, p SelectionPolicy) (*Representation, error) {
+	var best *RepresentationInfo
+	found := false
+
+	list := mpd.ListRepresentations()
+	for i := range list {
+		c := &list[i]
+		if c.Kind != kind {
+			continue
+		}
+		found = true
+		if !p.allows(c) {
+			continue
+		}
+		if best == nil || p.prefers(c, best) {
+			best = c
+		}
+	}
+
+	if best == nil {
+		if found {
+			return nil, fmt.Errorf("no %s representation matches the selection policy", kind)
+		}
+		return nil, fmt.Errorf("no %s representation found", kind)
+	}
+	return best.Representation, nil
+}
+
+func (p SelectionPolicy) allows(c *RepresentationInfo) bool {
+	if p.MaxHeight > 0 && c.Height > p.MaxHeight {
+		return false
+	}
+	if p.MaxFPS > 0 && c.FPS > p.MaxFPS {
+		return false
+	}
+	if p.MaxBandwidth > 0 && c.Bandwidth > p.MaxBandwidth {
+		return false
+	}
+	return true
+}
+
+// prefers reports whether a ranks strictly above b.
+func (p SelectionPolicy) prefers(a, b *RepresentationInfo) bool {
+	if p.Language != "" {
+		if am, bm := a.Lang == p.Language, b.Lang == p.Language; am != bm {
+			return am
+		}
+	}
+	if p.Codec != "" {
+		if am, bm := strings.HasPrefix(a.Codec, p.Codec), strings.HasPrefix(b.Codec, p.Codec); am != bm {
+			return am
+		}
+	}
+	if p.Height > 0 {
+		if ad, bd := abs(a.Height-p.Height), abs(b.Height-p.Height); ad != bd {
+			return ad < bd
+		}
+	} else if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	return a.Bandwidth > b.Bandwidth
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
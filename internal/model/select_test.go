@@ -0,0 +1,78 @@
+package model
+
+import "testing"
+
+func TestSelect_Policy(t *testing.T) {
+	mpd := &MPD{
+		Period: Period{
+			AdaptationSets: []AdaptationSet{
+				{
+					MimeType: "video/mp4",
+					Representations: []Representation{
+						{ID: "avc-720", Height: 720, Bandwidth: 1500000, Codecs: "avc1.64001f", FrameRate: "30"},
+						{ID: "avc-1080", Height: 1080, Bandwidth: 4000000, Codecs: "avc1.640028", FrameRate: "60"},
+						{ID: "hevc-1080", Height: 1080, Bandwidth: 2500000, Codecs: "hvc1.1.6.L120", FrameRate: "60"},
+					},
+				},
+				{
+					MimeType: "audio/mp4",
+					Lang:     "en",
+					Representations: []Representation{
+						{ID: "en", Bandwidth: 128000, Codecs: "mp4a.40.2"},
+					},
+				},
+				{
+					MimeType: "audio/mp4",
+					Lang:     "de",
+					Representations: []Representation{
+						{ID: "de", Bandwidth: 96000, Codecs: "opus"},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		kind       string
+		policy     SelectionPolicy
+		expectedID string
+	}{
+		{"Tallest without preferences", "video", SelectionPolicy{}, "avc-1080"},
+		{"Bandwidth breaks height ties", "video", SelectionPolicy{Height: 1080}, "avc-1080"},
+		{"Codec preference", "video", SelectionPolicy{Height: 1080, Codec: "hvc1"}, "hevc-1080"},
+		{"Codec outranks height", "video", SelectionPolicy{Height: 720, Codec: "hvc1"}, "hevc-1080"},
+		{"Max bandwidth constraint", "video", SelectionPolicy{Height: 1080, MaxBandwidth: 3000000}, "hevc-1080"},
+		{"Max FPS constraint", "video", SelectionPolicy{Height: 1080, MaxFPS: 30}, "avc-720"},
+		{"Max height constraint", "video", SelectionPolicy{MaxHeight: 720}, "avc-720"},
+		{"Audio language", "audio", SelectionPolicy{Language: "de"}, "de"},
+		{"Audio default", "audio", SelectionPolicy{}, "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, err := mpd.Select(tt.kind, tt.policy)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rep.ID != tt.expectedID {
+				t.Errorf("expected ID %s, got %s", tt.expectedID, rep.ID)
+			}
+		})
+	}
+}
+
+func TestSelect_NoMatch(t *testing.T) {
+	mpd := &MPD{
+		Period: Period{
+			AdaptationSets: []AdaptationSet{
+				{MimeType: "video/mp4", Representations: []Representation{{ID: "1080p", Height: 1080}}},
+			},
+		},
+	}
+
+	_, err := mpd.Select("video", SelectionPolicy{MaxHeight: 720})
+	if err == nil || err.Error() != "no video representation matches the selection policy" {
+		t.Errorf("expected policy mismatch error, got %v", err)
+	}
+}
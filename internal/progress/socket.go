@@ -0,0 +1,116 @@
+package progress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// writeTimeout bounds each write to a client. Publish runs on the download
+// path, so a client that stops reading is dropped rather than waited for.
+const writeTimeout = 250 * time.Millisecond
+
+// maxAcceptDelay caps the backoff between failed Accept calls, e.g. while the
+// process is out of file descriptors.
+const maxAcceptDelay = time.Second
+
+// Event is a single progress update, sent to socket clients as one JSON object per line.
+type Event struct {
+	Type             string `json:"type"` // "progress", "done", "error" or "cancelled"
+	Stream           string `json:"stream,omitempty"`
+	RepresentationID string `json:"representation_id,omitempty"`
+	Done             int    `json:"done"`
+	Total            int    `json:"total"`
+	Bytes            int64  `json:"bytes"`
+	Message          string `json:"message,omitempty"`
+}
+
+// Server broadcasts events to every client connected to a Unix socket.
+// Windows 10 and later support AF_UNIX sockets too, so the same code path is used there.
+type Server struct {
+	ln    net.Listener
+	path  string
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Listen creates the socket at path, replacing a stale socket left by a previous run.
+func Listen(path string) (*Server, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	s := &Server{ln: ln, path: path, conns: make(map[net.Conn]struct{})}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	var delay time.Duration
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		s.mu.Lock()
+		if s.conns == nil { // Closed while this client was connecting
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+// Publish sends ev to all connected clients, dropping any that can't be
+// written to within writeTimeout.
+func (s *Server) Publish(ev Event) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(data); err != nil {
+			_ = conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+}
+
+// Close disconnects all clients and removes the socket file.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+
+	_ = os.Remove(s.path)
+	return err
+}
@@ -0,0 +1,107 @@
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfs-dl.sock")
+	srv, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer func() { _ = srv.Close() }()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The accept loop runs asynchronously, so publish until the client sees an event
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		srv.Publish(Event{Type: "progress", Stream: "video", Done: 3, Total: 10})
+		select {
+		case line := <-lines:
+			var ev Event
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("invalid event %q: %v", line, err)
+			}
+			if ev.Type != "progress" || ev.Done != 3 || ev.Total != 10 {
+				t.Errorf("unexpected event %+v", ev)
+			}
+			return
+		case <-deadline:
+			t.Fatal("timed out waiting for event")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_StalledClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfs-dl.sock")
+	srv, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer func() { _ = srv.Close() }()
+
+	// The client never reads, so the socket buffer fills up
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	ev := Event{Type: "progress", Message: strings.Repeat("x", 64<<10)}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		start := time.Now()
+		srv.Publish(ev)
+		if d := time.Since(start); d > 2*writeTimeout {
+			t.Fatalf("Publish blocked for %v on a stalled client", d)
+		}
+		srv.mu.Lock()
+		n := len(srv.conns)
+		srv.mu.Unlock()
+		if n == 0 && time.Since(start) >= writeTimeout/2 {
+			return // Dropped after timing out
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client was never dropped")
+		}
+	}
+}
+
+func TestServer_CloseWhileAccepting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfs-dl.sock")
+	for i := 0; i < 20; i++ {
+		srv, err := Listen(path)
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			defer func() { _ = conn.Close() }()
+		}
+		_ = srv.Close()
+	}
+}
+
+func TestListen_Fail(t *testing.T) {
+	if _, err := Listen("/nonexistent/dir/cfs-dl.sock"); err == nil {
+		t.Error("expected error for unwritable socket path, got nil")
+	}
+}
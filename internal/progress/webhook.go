@@ -0,0 +1,202 @@
+package progress
+
+import (
+	"bytes"
+	"cfs-dl/internal/httpclient"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeTimeout bounds how long Close waits for the queued events, so a
+// receiver that never answers doesn't hold up Ctrl-C or --timeout.
+var closeTimeout = 5 * time.Second
+
+// WebhookPayload is the JSON body POSTed for each event.
+type WebhookPayload struct {
+	JobID string `json:"job_id"`
+	Event
+	Percent     float64 `json:"percent"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	ETASeconds  float64 `json:"eta_seconds,omitempty"`
+}
+
+// Webhook POSTs progress events to a URL, throttled so a chat bot can edit
+// one status message as the download goes: a "progress" event is sent once
+// Interval has passed or the percentage has grown by Step since the last one.
+// "done" and "error" events are always sent.
+type Webhook struct {
+	url      string
+	jobID    string
+	interval time.Duration
+	step     float64
+	client   *http.Client
+
+	mu      sync.Mutex
+	streams map[string]*webhookStream // By representation, as --all-formats has several per kind
+	err     error
+
+	queue   chan WebhookPayload
+	done    chan struct{}
+	stopped atomic.Bool     // Set by a "cancelled" or "error" event, after which queued progress is stale
+	ctx     context.Context // Cancelled when Close gives up
+	cancel  context.CancelFunc
+}
+
+// webhookStream tracks one stream's rate and what was last sent for it.
+type webhookStream struct {
+	start      time.Time
+	startDone  int
+	startBytes int64
+	sentAt     time.Time
+	sentPct    float64
+}
+
+// NewWebhook starts a Webhook posting to url. A zero interval and step send
+// every event. Requests go through the shared client's transport, so they
+// use the same proxy, but without its cookies.
+func NewWebhook(url, jobID string, interval time.Duration, step float64) *Webhook {
+	w := &Webhook{
+		url:      url,
+		jobID:    jobID,
+		interval: interval,
+		step:     step,
+		client:   &http.Client{Transport: httpclient.Shared().Transport, Timeout: 10 * time.Second},
+		streams:  make(map[string]*webhookStream),
+		queue:    make(chan WebhookPayload, 16),
+		done:     make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.send()
+	return w
+}
+
+// Publish queues ev if it is due. It never blocks: progress events are
+// dropped when the receiver can't keep up, and other events make room by
+// dropping the oldest queued one, a progress event in practice. Once the run
+// is cancelled or fails, progress events still queued are dropped.
+func (w *Webhook) Publish(ev Event) {
+	p, ok := w.payload(ev, time.Now())
+	if !ok {
+		return
+	}
+	if ev.Type == "cancelled" || ev.Type == "error" {
+		w.stopped.Store(true)
+	}
+	for {
+		select {
+		case w.queue <- p:
+			return
+		default:
+		}
+		if ev.Type == "progress" {
+			return
+		}
+		select {
+		case <-w.queue:
+		default:
+		}
+	}
+}
+
+func (w *Webhook) payload(ev Event, now time.Time) (WebhookPayload, bool) {
+	p := WebhookPayload{JobID: w.jobID, Event: ev}
+	if ev.Total > 0 {
+		p.Percent = float64(ev.Done) * 100 / float64(ev.Total)
+	}
+	if ev.Type != "progress" {
+		return p, true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := cmp.Or(ev.RepresentationID, ev.Stream)
+	s, ok := w.streams[key]
+	if !ok {
+		// Resumed work doesn't count towards the rate
+		s = &webhookStream{start: now, startDone: ev.Done, startBytes: ev.Bytes}
+		w.streams[key] = s
+	}
+	elapsed := now.Sub(s.start).Seconds()
+	if elapsed > 0 {
+		p.BytesPerSec = float64(ev.Bytes-s.startBytes) / elapsed
+		if done := ev.Done - s.startDone; done > 0 && ev.Total > ev.Done {
+			p.ETASeconds = float64(ev.Total-ev.Done) * elapsed / float64(done)
+		}
+	}
+
+	due := !ok || ev.Done == ev.Total ||
+		(w.interval <= 0 && w.step <= 0) ||
+		(w.interval > 0 && now.Sub(s.sentAt) >= w.interval) ||
+		(w.step > 0 && p.Percent-s.sentPct >= w.step)
+	if !due {
+		return p, false
+	}
+	s.sentAt, s.sentPct = now, p.Percent
+	return p, true
+}
+
+func (w *Webhook) send() {
+	defer close(w.done)
+	for p := range w.queue {
+		if p.Type == "progress" && w.stopped.Load() {
+			continue
+		}
+		if err := w.post(p); err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *Webhook) post(p WebhookPayload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// Close sends the queued events and returns the first error posting any of
+// them. It gives up after closeTimeout.
+func (w *Webhook) Close() error {
+	close(w.queue)
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.done:
+	case <-timer.C:
+		w.cancel()
+		<-w.done
+		w.mu.Lock()
+		w.err = fmt.Errorf("%s did not answer within %s", w.url, closeTimeout)
+		w.mu.Unlock()
+	}
+	w.cancel()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,155 @@
+package progress
+
+import (
+	"cfs-dl/internal/httpclient"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Throttle(t *testing.T) {
+	w := &Webhook{jobID: "job", interval: 10 * time.Second, step: 25, streams: make(map[string]*webhookStream)}
+	start := time.Now()
+	ev := func(done int) Event {
+		return Event{Type: "progress", Stream: "video", Done: done, Total: 100, Bytes: int64(done) * 1000}
+	}
+
+	tests := []struct {
+		name string
+		ev   Event
+		at   time.Duration
+		want bool
+	}{
+		{"First update", ev(10), 0, true},
+		{"Too soon", ev(20), time.Second, false},
+		{"Step reached", ev(35), 2 * time.Second, true},
+		{"Interval reached", ev(40), 12 * time.Second, true},
+		{"Finished", ev(100), 13 * time.Second, true},
+		{"Other stream", Event{Type: "progress", Stream: "audio", Done: 1, Total: 100}, 13 * time.Second, true},
+		// --all-formats downloads several representations of one kind
+		{"Other representation", Event{Type: "progress", Stream: "video", RepresentationID: "720p", Done: 1, Total: 100}, 13 * time.Second, true},
+		{"Representation too soon", Event{Type: "progress", Stream: "video", RepresentationID: "720p", Done: 2, Total: 100}, 14 * time.Second, false},
+		{"Done event", Event{Type: "done", Message: "out.mp4"}, 13 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := w.payload(tt.ev, start.Add(tt.at)); got != tt.want {
+				t.Errorf("expected due=%v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWebhook_Rate(t *testing.T) {
+	w := &Webhook{jobID: "job", streams: make(map[string]*webhookStream)}
+	start := time.Now()
+	// Resumed at 20 of 100 segments; the rate only counts this run's work
+	w.payload(Event{Type: "progress", Stream: "video", Done: 20, Total: 100, Bytes: 20000}, start)
+	p, _ := w.payload(Event{Type: "progress", Stream: "video", Done: 40, Total: 100, Bytes: 40000}, start.Add(10*time.Second))
+
+	if p.JobID != "job" || p.Percent != 40 {
+		t.Errorf("unexpected payload %+v", p)
+	}
+	if p.BytesPerSec != 2000 {
+		t.Errorf("expected 2000 bytes/s, got %v", p.BytesPerSec)
+	}
+	// 60 segments left at 2 per second
+	if p.ETASeconds != 30 {
+		t.Errorf("expected ETA 30s, got %v", p.ETASeconds)
+	}
+}
+
+func TestWebhook_Post(t *testing.T) {
+	var mu sync.Mutex
+	var got []WebhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, "abc", 0, 0)
+	w.Publish(Event{Type: "progress", Stream: "video", Done: 1, Total: 2})
+	w.Publish(Event{Type: "done", Message: "out.mp4"})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if len(got) != 2 || got[0].Percent != 50 || got[1].Type != "done" || got[1].JobID != "abc" {
+		t.Errorf("unexpected payloads %+v", got)
+	}
+}
+
+func TestWebhook_PostError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, "abc", 0, 0)
+	w.Publish(Event{Type: "done"})
+	if err := w.Close(); err == nil {
+		t.Error("expected error for a failing webhook, got nil")
+	}
+}
+
+func TestWebhook_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	client, err := httpclient.New(httpclient.Options{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpclient.SetShared(httpclient.Shared())
+	httpclient.SetShared(client)
+
+	w := NewWebhook("http://hooks.example/progress", "abc", 0, 0)
+	w.Publish(Event{Type: "done"})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if proxied != "http://hooks.example/progress" {
+		t.Errorf("expected the webhook to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestWebhook_CloseUnresponsive(t *testing.T) {
+	defer func(d time.Duration) { closeTimeout = d }(closeTimeout)
+	closeTimeout = 50 * time.Millisecond
+
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	w := NewWebhook(srv.URL, "abc", 0, 0)
+	// More events than the queue holds, none of which the receiver answers
+	for i := range 40 {
+		w.Publish(Event{Type: "progress", Stream: "video", Done: i, Total: 40})
+	}
+	w.Publish(Event{Type: "cancelled"})
+
+	start := time.Now()
+	if err := w.Close(); err == nil {
+		t.Error("expected error for an unresponsive webhook, got nil")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected Close to give up after %s, took %s", closeTimeout, d)
+	}
+}